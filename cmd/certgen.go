@@ -4,12 +4,17 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"os"
 	"strings"
 
 	"github.com/cilium/certgen/internal/defaults"
+	"github.com/cilium/certgen/internal/events"
 	"github.com/cilium/certgen/internal/generate"
+	"github.com/cilium/certgen/internal/lock"
 	"github.com/cilium/certgen/internal/logging"
 	"github.com/cilium/certgen/internal/logging/logfields"
 	"github.com/cilium/certgen/internal/option"
@@ -18,6 +23,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	v1 "k8s.io/api/core/v1"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -38,7 +44,14 @@ func New() (*cobra.Command, error) {
 		SilenceErrors: true,
 		Version:       version.Version,
 		Run: func(cmd *cobra.Command, args []string) {
-			option.Config.PopulateFrom(vp)
+			if err := option.Config.PopulateFrom(vp); err != nil {
+				log.WithError(err).Fatal("failed to load configuration")
+			}
+			if option.Config.CertReuseSecret || option.Config.CertManagerMode != "" {
+				if err := option.Config.ValidateRenewBefore(); err != nil {
+					log.WithError(err).Fatal("failed to load configuration")
+				}
+			}
 
 			if option.Config.Debug {
 				logging.DefaultLogger.SetLevel(logrus.DebugLevel)
@@ -53,21 +66,101 @@ func New() (*cobra.Command, error) {
 	}
 	rootCmd.SetVersionTemplate("{{with .Name}}{{printf \"%s \" .}}{{end}}{{printf \"v%s\" .Version}}\n")
 
-	flags := rootCmd.Flags()
+	rootCmd.AddCommand(newControllerCmd(vp))
+	rootCmd.AddCommand(newRenderCmd(vp))
+	rootCmd.AddCommand(newInspectCmd(vp))
+	rootCmd.AddCommand(newVerifyCmd(vp))
+
+	flags := rootCmd.PersistentFlags()
 	flags.BoolP(option.Debug, "D", defaults.Debug, "Enable debug messages")
+	flags.String(option.SpecFile, "", "Path to a YAML or JSON file listing the certificates to generate (it may also set any of the other flags)")
+	flags.Bool(option.DryRun, defaults.DryRun, "Only print the changes which would be made to the CA and certificate secrets, without writing any secret")
+	flags.String(option.DryRunOutput, defaults.DryRunOutput, "Format of the plan printed in dry-run mode (text or json)")
+	flags.StringSlice(option.OutputSinks, defaults.OutputSinks, "Where to store the generated CAs and certificates: secret (K8s Secrets), file (PEM files in --output-dir) and/or manifest (Secret manifests on stdout)")
+	flags.String(option.OutputDir, "", "Directory in which the file output sink writes a <namespace>/<name> directory per secret")
+	flags.String(option.RenderOutput, "", "Path to the file the render command writes the Secret manifests to (stdout if empty)")
+	flags.String(option.ReportOutput, "", "Path to the file the JSON run report is written to (- for stdout)")
+	flags.String(option.ReportConfigMapName, "", "Name of the K8s ConfigMap the JSON run report is stored in")
+	flags.String(option.ReportConfigMapNamespace, "", "Overwrites the namespace of the K8s ConfigMap the JSON run report is stored in")
+	flags.Bool(option.Events, defaults.Events, "Emit K8s Events on the CA and certificate secrets, and on the certgen Job or Pod")
+	flags.String(option.InspectOutput, defaults.InspectOutput, "Format of the report printed by the inspect command (text or json)")
+	flags.String(option.InspectWarnBefore, defaults.InspectWarnBefore, "Window before expiry in which the inspect command reports certificates as expiring, as duration (e.g. 720h) or percentage of the lifetime (e.g. 33%)")
 
 	flags.String(option.K8sKubeConfigPath, "", "Path to the K8s kubeconfig file. If absent, the in-cluster config is used.")
 	flags.Duration(option.K8sRequestTimeout, defaults.K8sRequestTimeout, "Timeout for K8s API requests")
+	flags.Float32(option.K8sClientQPS, defaults.K8sClientQPS, "Maximum sustained rate of K8s API requests per second")
+	flags.Int(option.K8sClientBurst, defaults.K8sClientBurst, "Maximum burst of K8s API requests")
+	flags.Int(option.Workers, defaults.Workers, "Number of certificates generated and stored concurrently (0 for the number of CPUs)")
+	flags.Bool(option.ForceConflicts, defaults.ForceConflicts, "Take ownership of the secret fields managed by other field managers when applying secrets, instead of failing")
+	flags.Bool(option.LeaseLock, defaults.LeaseLock, "Acquire a K8s Lease before loading the CAs and writing the secrets, so that concurrent runs cannot race")
+	flags.String(option.LeaseLockName, defaults.LeaseLockName, "Name of the K8s Lease used as lock")
+	flags.String(option.LeaseLockNamespace, "", "Overwrites the namespace of the K8s Lease used as lock")
+	flags.Duration(option.LeaseLockTimeout, defaults.LeaseLockTimeout, "Maximum time to wait for the K8s Lease held by another run")
 
 	flags.String(option.CACertFile, "", "Path to provided Cilium CA certificate file (required if Cilium CA is not generated)")
 	flags.String(option.CAKeyFile, "", "Path to provided Cilium CA key file (required if Cilium CA is not generated)")
+	flags.Bool(option.CAStoreFile, defaults.CAStoreFile, "Store the Cilium CA loaded from the provided files in the CA secret")
+	flags.String(option.CAKeyPassphraseFile, "", "Path to the file holding the passphrase of the encrypted Cilium CA key")
+	flags.String(option.CAKeyPassphraseEnv, "", "Name of the environment variable holding the passphrase of the encrypted Cilium CA key")
+	flags.String(option.CAKeyPassphraseSecretName, "", "Name of the K8s Secret holding the passphrase of the encrypted Cilium CA key")
+	flags.String(option.CAKeyPassphraseSecretNamespace, "", "Overwrites the namespace of the K8s Secret holding the passphrase of the encrypted Cilium CA key")
+	flags.String(option.CAKeyPassphraseSecretKey, defaults.CAKeyPassphraseSecretKey, "Key of the K8s Secret holding the passphrase of the encrypted Cilium CA key")
+	flags.Bool(option.CAKeyEncrypt, defaults.CAKeyEncrypt, "Encrypt the Cilium CA key stored in the CA secret with the CA key passphrase")
+
+	flags.String(option.RemoteSignerURL, "", "URL of the remote cfssl server issuing the certificates instead of the Cilium CA (e.g. https://cfssl.example.com:8888)")
+	flags.String(option.RemoteSignerProfile, "", "Signing profile requested from the remote cfssl server (the server default if empty)")
+	flags.String(option.RemoteSignerLabel, "", "Label selecting the CA of a multi-root remote cfssl server")
+	flags.String(option.RemoteSignerAuthKeyFile, "", "Path to the file holding the hex encoded key authenticating the requests to the remote cfssl server")
+	flags.String(option.RemoteSignerTLSCAFile, "", "Path to the CA certificates verifying the TLS certificate of the remote cfssl server (the system roots if empty)")
+	flags.String(option.RemoteSignerCAChainFile, "", "Path to the parent CA certificates of the remote cfssl server CA up to the root, if it is an intermediate CA")
+	flags.Duration(option.RemoteSignerTimeout, defaults.RemoteSignerTimeout, "Timeout for remote cfssl server requests")
+
+	flags.String(option.CSRSignerName, "", "Signer name of the K8s CertificateSigningRequests through which the certificates are issued instead of the Cilium CA")
+	flags.Bool(option.CSRAutoApprove, defaults.CSRAutoApprove, "Approve the K8s CertificateSigningRequests created by certgen")
+	flags.Duration(option.CSRTimeout, defaults.CSRTimeout, "Maximum time to wait for a K8s CertificateSigningRequest to be issued")
+	flags.String(option.CSRCABundleConfigMapName, "", "Name of the K8s ConfigMap holding the CA bundle of the CSR signer")
+	flags.String(option.CSRCABundleSecretName, "", "Name of the K8s Secret holding the CA bundle of the CSR signer")
+	flags.String(option.CSRCABundleNamespace, "", "Overwrites the namespace of the K8s ConfigMap or Secret holding the CA bundle of the CSR signer")
+	flags.String(option.CSRCABundleKey, defaults.CSRCABundleKey, "Key of the K8s ConfigMap or Secret holding the CA bundle of the CSR signer")
+
+	flags.String(option.CASignerName, "", "Signer name of the K8s CertificateSigningRequests the controller signs with the Cilium CA (e.g. cilium.io/cilium-ca)")
+	flags.StringSlice(option.CASignerAllowedUsages, defaults.CASignerAllowedUsages, "Key usages which may be requested from the Cilium CA signer")
+	flags.StringSlice(option.CASignerAllowedDNSNames, defaults.CASignerAllowedDNSNames, "DNS names which may be requested as SAN or CN from the Cilium CA signer (*.example.com matches any subdomain)")
+	flags.StringSlice(option.CASignerAllowedIPRanges, nil, "CIDRs of the IP address SANs which may be requested from the Cilium CA signer")
+	flags.Duration(option.CASignerMaxDuration, defaults.CASignerMaxDuration, "Maximum validity of the certificates signed by the Cilium CA signer")
+
+	flags.String(option.CertManagerMode, "", "Emit (emit) or apply (apply) a cert-manager Issuer backed by the Cilium CA secret and a cert-manager Certificate per certificate, instead of issuing the certificates")
+	flags.String(option.CertManagerIssuerName, defaults.CertManagerIssuerName, "Name of the cert-manager Issuer backed by the Cilium CA secret")
 
 	flags.Bool(option.CAGenerate, defaults.CAGenerate, "Generate and store Cilium CA certificate")
 	flags.Bool(option.CAReuseSecret, defaults.CAReuseSecret, "Reuse the Cilium CA secret if it exists, otherwise generate a new one")
 	flags.String(option.CACommonName, defaults.CACommonName, "Cilium CA common name")
 	flags.Duration(option.CAValidityDuration, defaults.CAValidityDuration, "Cilium CA validity duration")
+	flags.String(option.CAKeyAlgorithm, defaults.CAKeyAlgorithm, "Cilium CA key algorithm (ecdsa, rsa or ed25519)")
+	flags.Int(option.CAKeySize, defaults.CAKeySize, "Cilium CA key size (256, 384 or 521 for ecdsa, 2048, 3072 or 4096 for rsa, 0 for the default)")
+	flags.String(option.CACountry, defaults.CACountry, "Cilium CA subject country (C)")
+	flags.String(option.CAState, defaults.CAState, "Cilium CA subject state or province (ST)")
+	flags.String(option.CALocality, defaults.CALocality, "Cilium CA subject locality (L)")
+	flags.String(option.CAOrganization, defaults.CAOrganization, "Cilium CA subject organization (O)")
+	flags.String(option.CAOrganizationalUnit, defaults.CAOrganizationalUnit, "Cilium CA subject organizational unit (OU)")
+	flags.String(option.CASerialNumber, defaults.CASerialNumber, "Cilium CA subject serial number")
 	flags.String(option.CASecretName, defaults.CASecretName, "Name of the K8s Secret where the Cilium CA cert and key are stored in")
 	flags.String(option.CASecretNamespace, "", "Overwrites the namespace of the K8s Secret where the Cilium CA cert and key are stored in")
+	flags.Bool(option.CARotate, defaults.CARotate, "Start a phased rotation of the Cilium CA stored in the CA secret (in-progress rotations always move forward)")
+	flags.Duration(option.CARotationGracePeriod, defaults.CARotationGracePeriod, "Minimum time between two phases of a Cilium CA rotation")
+
+	flags.Bool(option.CertReuseSecret, defaults.CertReuseSecret, "Keep the existing certificate secrets unless they are missing, unparsable or due for renewal")
+	flags.String(option.CertRenewBefore, defaults.CertRenewBefore, "Window before expiry in which certificates are renewed, as duration (e.g. 720h) or percentage of the lifetime (e.g. 33%)")
+	flags.String(option.CertKeyAlgorithm, defaults.CertKeyAlgorithm, "Certificate key algorithm (ecdsa, rsa or ed25519), unless set in the spec file")
+	flags.Int(option.CertKeySize, defaults.CertKeySize, "Certificate key size (256, 384 or 521 for ecdsa, 2048, 3072 or 4096 for rsa, 0 for the default), unless set in the spec file")
+	flags.String(option.CertCountry, "", "Certificate subject country (C), unless set in the spec file")
+	flags.String(option.CertState, "", "Certificate subject state or province (ST), unless set in the spec file")
+	flags.String(option.CertLocality, "", "Certificate subject locality (L), unless set in the spec file")
+	flags.String(option.CertOrganization, "", "Certificate subject organization (O), unless set in the spec file")
+	flags.String(option.CertOrganizationalUnit, "", "Certificate subject organizational unit (OU), unless set in the spec file")
+	flags.String(option.CertSerialNumber, "", "Certificate subject serial number, unless set in the spec file")
+	flags.StringToString(option.SecretLabels, nil, "Extra labels set on every CA and certificate secret (e.g. team=net,env=prod)")
+	flags.StringToString(option.SecretAnnotations, nil, "Extra annotations set on every CA and certificate secret")
 
 	flags.Bool(option.HubbleRelayClientCertGenerate, defaults.HubbleRelayClientCertGenerate, "Generate and store Hubble Relay client certificate")
 	flags.String(option.HubbleRelayClientCertCommonName, defaults.HubbleRelayClientCertCommonName, "Hubble Relay client certificate common name")
@@ -141,6 +234,16 @@ func Execute() error {
 // k8sConfig creates a new Kubernetes config either based on the provided
 // kubeconfig file or alternatively the in-cluster configuration.
 func k8sConfig(kubeconfig string) (*kubernetes.Clientset, error) {
+	config, err := k8sRestConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// k8sRestConfig returns the K8s client configuration read from kubeconfig, or
+// the in-cluster configuration if empty.
+func k8sRestConfig(kubeconfig string) (*rest.Config, error) {
 	var config *rest.Config
 	var err error
 	if kubeconfig == "" {
@@ -152,44 +255,340 @@ func k8sConfig(kubeconfig string) (*kubernetes.Clientset, error) {
 	if err != nil {
 		return nil, err
 	}
-	return kubernetes.NewForConfig(config)
+	config.QPS = option.Config.K8sClientQPS
+	config.Burst = option.Config.K8sClientBurst
+	return config, nil
 }
 
 // generateCertificates runs the main code to generate and store certificate
-func generateCertificates() error {
+func generateCertificates() (err error) {
 	k8sClient, err := k8sConfig(option.Config.K8sKubeConfigPath)
 	if err != nil {
 		return fmt.Errorf("failed initialize kubernetes client: %w", err)
 	}
 
-	// Store after all the requested certs have been successfully generated
-	count := 0
+	var p *planner
+	if option.Config.DryRun {
+		log.Info("Running in dry-run mode, no secret will be written")
+		p = &planner{}
+	}
 
-	ciliumCA := generate.NewCA(option.Config.CASecretName, option.Config.CASecretNamespace)
+	var r *generate.Report
+	if p == nil && (reportEnabled() || option.Config.Events) {
+		r = generate.NewReport()
+		var recorder *events.Recorder
+		if option.Config.Events {
+			recorder = events.NewRecorder(k8sClient, binaryName, option.Config.K8sKubeConfigPath == "", option.Config.K8sRequestTimeout)
+		}
+		defer func() {
+			r.Finish(err)
+			if recorder != nil {
+				emitEvents(recorder, r)
+				recorder.Shutdown(option.Config.K8sRequestTimeout)
+			}
+			if !reportEnabled() {
+				return
+			}
+			if rerr := writeReport(k8sClient, r); rerr != nil {
+				err = errors.Join(err, rerr)
+			}
+		}()
+	}
 
-	if option.Config.CAGenerate {
-		err = ciliumCA.Generate(option.Config.CACommonName, option.Config.CAValidityDuration)
+	// Store after all the requested certs have been successfully generated
+	sink, tx := newSink(k8sClient)
+	// In cert-manager emit mode, the Cilium CA secret is emitted along with
+	// the cert-manager resources once they are ready, rather than stored.
+	var emitted bytes.Buffer
+	if option.Config.CertManagerMode == option.CertManagerModeEmit {
+		sink, tx = emitSink{Sink: generate.NewManifestSink(&emitted), k8sClient: k8sClient}, nil
+	}
+
+	if p == nil {
+		// The CAs are loaded only once the lock is held, so that a CA
+		// generated and stored by a concurrent run is reused rather than
+		// overwritten.
+		lockCtx, release, err := acquireLock(k8sClient)
 		if err != nil {
-			return fmt.Errorf("failed to generate Cilium CA: %w", err)
+			return err
+		}
+		defer release()
+		sink = lockedSink{Sink: sink, lockCtx: lockCtx}
+	}
+	cas, count, err := loadCAs(k8sClient, sink, p, r)
+	if err != nil {
+		return rollback(tx, err)
+	}
+
+	if p != nil {
+		return planCertificates(k8sClient, cas, p)
+	}
+
+	if option.Config.CertManagerMode != "" {
+		if err := runCertManager(cas[defaults.CAName], emitted.Bytes()); err != nil {
+			return rollback(tx, err)
+		}
+		log.WithField(logfields.Count, len(option.Config.Certificates)).Info("Handed the certificates over to cert-manager")
+		return nil
+	}
+
+	stored, err := storeCertificates(k8sClient, cas, sink, r)
+	if err != nil {
+		return rollback(tx, err)
+	}
+	count += stored
+
+	log.Infof("Successfully generated all %d requested certificates.", count)
+
+	return nil
+}
+
+// storeCertificates generates the requested certificates, issued by cas, and
+// stores them in sink. Unless certificates are reused, k8sClient is not used
+// and may be nil. It returns the number of certificates which have been stored,
+// and records the actions taken in r, if not nil.
+func storeCertificates(k8sClient *kubernetes.Clientset, cas map[string]*generate.CA, sink generate.Sink, r *generate.Report) (int, error) {
+	var err error
+
+	// The certificates to generate, with their issuing CA, and to store
+	generated := make([]*generate.Cert, 0, len(option.Config.Certificates))
+	issuers := make([]*generate.CA, 0, len(option.Config.Certificates))
+	certs := make([]*generate.Cert, 0, len(option.Config.Certificates))
+	all := make([]*generate.Cert, 0, len(option.Config.Certificates))
+	actions := make([]generate.Action, 0, len(option.Config.Certificates))
+	for _, spec := range option.Config.Certificates {
+		scopedLog := log.WithField(logfields.CertName, spec.Name)
+		cert := spec.NewCert()
+
+		action := generate.ActionCreated
+		if option.Config.CertReuseSecret {
+			action, err = checkRenewal(cert, cas[spec.CA], spec.RenewBefore, k8sClient)
+			if err != nil {
+				return 0, fmt.Errorf("failed to check renewal of %s cert: %w", spec.Name, err)
+			}
 		}
+
+		if action == generate.ActionCreated || action == generate.ActionRenewed {
+			scopedLog.Info("Generating certificate")
+			generated = append(generated, cert)
+			issuers = append(issuers, cas[spec.CA])
+		}
+		if action != generate.ActionKept {
+			certs = append(certs, cert)
+		}
+		all = append(all, cert)
+		actions = append(actions, action)
+	}
+
+	workers := generate.Workers(option.Config.Workers)
+	if err := generate.GenerateAll(generated, issuers, workers); err != nil {
+		return 0, err
+	}
+	if err := generate.StoreAll(certs, sink, workers, option.Config.K8sRequestTimeout); err != nil {
+		return 0, err
+	}
+
+	for i, spec := range option.Config.Certificates {
+		r.AddCert(spec.Name, all[i], actions[i])
+	}
+
+	if option.Config.CertReuseSecret {
+		for i, spec := range option.Config.Certificates {
+			log.WithFields(logrus.Fields{
+				logfields.CertName:           spec.Name,
+				logfields.Action:             actions[i],
+				logfields.K8sSecretNamespace: spec.SecretNamespace,
+				logfields.K8sSecretName:      spec.SecretName,
+			}).Info("Certificate processed")
+		}
+	}
+
+	return len(certs), nil
+}
+
+// acquireLock acquires the K8s Lease used as lock if the lease-lock option is
+// set. The returned context is canceled once the lease is lost, and the
+// returned function releases it.
+func acquireLock(k8sClient *kubernetes.Clientset) (context.Context, func(), error) {
+	if !option.Config.LeaseLock {
+		return context.Background(), func() {}, nil
+	}
+
+	l, err := lock.Acquire(k8sClient, option.Config.LeaseLockNamespace, option.Config.LeaseLockName,
+		option.Config.LeaseLockTimeout, option.Config.K8sRequestTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	return l.Context(), func() {
+		if err := l.Release(); err != nil {
+			log.WithError(err).Warn("Failed to release K8s Lease, other runs will wait for it to expire")
+		}
+	}, nil
+}
+
+// lockedSink stores secrets in a sink as long as the K8s Lease used as lock is
+// held, and aborts the stores in flight once it is lost.
+type lockedSink struct {
+	generate.Sink
+	lockCtx context.Context
+}
+
+// Store implements generate.Sink
+func (s lockedSink) Store(ctx context.Context, secret *v1.Secret, force bool) error {
+	ctx, cancel := withLock(ctx, s.lockCtx)
+	defer cancel()
+	err := context.Cause(ctx)
+	if err == nil {
+		err = s.Sink.Store(ctx, secret, force)
+	}
+	if err != nil && s.lockCtx.Err() != nil {
+		return context.Cause(s.lockCtx)
+	}
+	return err
+}
+
+// withLock returns a copy of ctx which is also canceled, with the same cause,
+// once lockCtx is canceled.
+func withLock(ctx, lockCtx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(lockCtx, func() { cancel(context.Cause(lockCtx)) })
+	return ctx, func() {
+		stop()
+		cancel(context.Canceled)
+	}
+}
+
+// rollback rolls back the secrets written by tx (if any) after err occurred,
+// and returns err annotated with the rollback result.
+func rollback(tx *generate.Transaction, err error) error {
+	if tx == nil || tx.Writes() == 0 {
+		return err
+	}
+	if errors.Is(err, lock.ErrLost) {
+		// Another run may have acquired the lease and written the secrets
+		// since, rolling them back could overwrite its writes.
+		log.WithError(err).Warnf("Not rolling back the %d K8s Secrets written before losing the K8s Lease", tx.Writes())
+		return err
+	}
+
+	log.WithError(err).Warnf("Rolling back the %d K8s Secrets written before the failure", tx.Writes())
+	result := tx.Rollback()
+	if result.Err != nil {
+		log.WithError(result.Err).Errorf("Rollback incomplete (%s), secrets may be inconsistent", result)
+		return fmt.Errorf("%w (%s)", err, result)
+	}
+	log.Infof("Rollback completed, %s", result)
+	return fmt.Errorf("%w (rolled back: %s)", err, result)
+}
+
+// newSink creates the sink storing the generated CAs and certificates, as
+// configured by the output-sinks option. K8s Secrets are written through the
+// returned transaction, if any, so that they can be rolled back on failure.
+func newSink(k8sClient *kubernetes.Clientset) (generate.Sink, *generate.Transaction) {
+	var sinks generate.MultiSink
+	var tx *generate.Transaction
+	for _, name := range option.Config.OutputSinks {
+		switch name {
+		case option.OutputSinkSecret:
+			secretSink := generate.NewSecretSink(k8sClient).WithForceConflicts(option.Config.ForceConflicts)
+			tx = generate.NewTransaction(secretSink, option.Config.K8sRequestTimeout)
+			sinks = append(sinks, tx)
+		case option.OutputSinkFile:
+			sinks = append(sinks, generate.NewFileSink(option.Config.OutputDir))
+		case option.OutputSinkManifest:
+			sinks = append(sinks, generate.NewManifestSink(os.Stdout))
+		}
+	}
+	if len(sinks) == 1 {
+		return sinks[0], tx
+	}
+	return sinks, tx
+}
+
+// loadCAs loads or generates the Cilium CA and the additional CAs listed in the
+// spec file, storing the generated ones. It returns the CAs by name, and the
+// number of CAs which have been stored in sink. If p is not nil, the CAs are not
+// stored but the planned changes are recorded in p. Otherwise, the actions taken
+// are recorded in r, if not nil.
+func loadCAs(k8sClient *kubernetes.Clientset, sink generate.Sink, p *planner, r *generate.Report) (map[string]*generate.CA, int, error) {
+	count := 0
+	stored := false
+
+	passphrase, err := caKeyPassphrase(k8sClient)
+	if err != nil {
+		return nil, 0, err
+	}
+	ciliumCA := generate.NewCA(option.Config.CASecretName, option.Config.CASecretNamespace).
+		WithKey(option.Config.CAKeyAlgorithm, option.Config.CAKeySize).
+		WithSubject(option.Config.CASubject()).
+		WithMetadata(option.Config.CAMetadata()).
+		WithKeyPassphrase(passphrase, option.Config.CAKeyEncrypt).
+		WithTLSKeys(option.Config.CertManagerMode != "")
+
+	// storeCiliumCA stores the Cilium CA, overwriting the existing secret
+	// unless the CA secret is reused
+	storeCiliumCA := func() error {
 		ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
 		defer cancel()
+		if p != nil {
+			return p.storeCA(ctx, k8sClient, defaults.CAName, ciliumCA, !option.Config.CAReuseSecret)
+		}
+		return ciliumCA.Store(ctx, sink, !option.Config.CAReuseSecret)
+	}
 
-		err = ciliumCA.StoreAsSecret(ctx, k8sClient, !option.Config.CAReuseSecret)
+	keptReason := "loaded from file, not stored"
+	if option.Config.RemoteSignerURL != "" {
+		log.Info("Loading Cilium CA from remote signer")
+		// The remote CA has no key to store in the CA secret.
+		ciliumCA.SecretName, ciliumCA.SecretNamespace = "", ""
+		if err = loadRemoteCA(ciliumCA); err != nil {
+			return nil, 0, fmt.Errorf("failed to load Cilium CA from remote signer: %w", err)
+		}
+		keptReason = "issued by remote signer"
+	} else if option.Config.CSRSignerName != "" {
+		log.Info("Loading Cilium CA bundle of K8s CertificateSigningRequest signer")
+		// The CA of the CSR signer is unknown and has no key to store in the
+		// CA secret.
+		ciliumCA.SecretName, ciliumCA.SecretNamespace = "", ""
+		if err = loadCSRSignerCA(k8sClient, ciliumCA); err != nil {
+			return nil, 0, fmt.Errorf("failed to load Cilium CA bundle of CSR signer: %w", err)
+		}
+		keptReason = "issued through K8s CertificateSigningRequests"
+	} else if option.Config.CAGenerate {
+		err = ciliumCA.Generate(option.Config.CACommonName, option.Config.CAValidityDuration)
 		if err != nil {
+			return nil, 0, fmt.Errorf("failed to generate Cilium CA: %w", err)
+		}
+		if err = storeCiliumCA(); err != nil {
 			if !k8sErrors.IsAlreadyExists(err) || !option.Config.CAReuseSecret {
-				return fmt.Errorf("failed to create secret for Cilium CA: %w", err)
+				return nil, 0, fmt.Errorf("failed to create secret for Cilium CA: %w", err)
 			}
 			// reset so that we can re-load later as CAReuseSecret is true
 			ciliumCA.Reset()
 		} else {
 			count++
+			stored = true
 		}
 	} else if option.Config.CACertFile != "" && option.Config.CAKeyFile != "" {
 		log.Info("Loading Cilium CA from file")
 		err = ciliumCA.LoadFromFile(option.Config.CACertFile, option.Config.CAKeyFile)
 		if err != nil {
-			return fmt.Errorf("failed to load Cilium CA from file: %w", err)
+			return nil, 0, fmt.Errorf("failed to load Cilium CA from file: %w", err)
+		}
+		if option.Config.CAStoreFile {
+			err = storeCiliumCA()
+			switch {
+			case err == nil:
+				count++
+				stored = true
+			case k8sErrors.IsAlreadyExists(err) && option.Config.CAReuseSecret:
+				if err := checkStoredCA(k8sClient, ciliumCA); err != nil {
+					return nil, 0, err
+				}
+				keptReason = "loaded from file, already stored"
+			default:
+				return nil, 0, fmt.Errorf("failed to create secret for Cilium CA: %w", err)
+			}
 		}
 	}
 
@@ -198,217 +597,194 @@ func generateCertificates() error {
 		defer cancel()
 		err = ciliumCA.LoadFromSecret(ctx, k8sClient)
 		if err != nil {
-			return fmt.Errorf("failed to load Cilium CA from secret: %w", err)
+			return nil, 0, fmt.Errorf("failed to load Cilium CA from secret: %w", err)
 		}
 		log.Info("Loaded Cilium CA Secret")
 	}
 
-	var hubbleServerCert *generate.Cert
-	if option.Config.HubbleServerCertGenerate {
-		log.Info("Generating server certificates for Hubble")
-		hubbleServerCert = generate.NewCert(
-			option.Config.HubbleServerCertCommonName,
-			option.Config.HubbleServerCertValidityDuration,
-			defaults.HubbleServerCertUsage,
-			option.Config.HubbleServerCertSecretName,
-			option.Config.HubbleServerCertSecretNamespace,
-		)
-		err := hubbleServerCert.Generate(ciliumCA)
+	rotated := false
+	if ciliumCA.LoadedFromSecret() {
+		rotated, err = ciliumCA.AdvanceRotation(option.Config.CARotate,
+			option.Config.CACommonName, option.Config.CAValidityDuration, option.Config.CARotationGracePeriod)
 		if err != nil {
-			return fmt.Errorf("failed to generate Hubble server cert: %w", err)
+			return nil, 0, fmt.Errorf("failed to rotate Cilium CA: %w", err)
 		}
+	} else if option.Config.CARotate {
+		log.Warn("Cilium CA not loaded from secret, skipping CA rotation")
 	}
 
-	var hubbleMetricsServerCert *generate.Cert
-	if option.Config.HubbleMetricsServerCertGenerate {
-		log.Info("Generating server certificates for Hubble")
-		hubbleMetricsServerCert = generate.NewCert(
-			option.Config.HubbleMetricsServerCertCommonName,
-			option.Config.HubbleMetricsServerCertValidityDuration,
-			defaults.HubbleMetricsServerCertUsage,
-			option.Config.HubbleMetricsServerCertSecretName,
-			option.Config.HubbleMetricsServerCertSecretNamespace,
-		)
-		err := hubbleMetricsServerCert.Generate(ciliumCA)
-		if err != nil {
-			return fmt.Errorf("failed to generate Hubble server cert: %w", err)
-		}
-	}
-
-	var hubbleRelayClientCert *generate.Cert
-	if option.Config.HubbleRelayClientCertGenerate {
-		log.Info("Generating client certificates for Hubble Relay")
-		hubbleRelayClientCert = generate.NewCert(
-			option.Config.HubbleRelayClientCertCommonName,
-			option.Config.HubbleRelayClientCertValidityDuration,
-			defaults.HubbleRelayClientCertUsage,
-			option.Config.HubbleRelayClientCertSecretName,
-			option.Config.HubbleRelayClientCertSecretNamespace,
-		)
-		err := hubbleRelayClientCert.Generate(ciliumCA)
-		if err != nil {
-			return fmt.Errorf("failed to generate Hubble Relay client cert: %w", err)
+	switch {
+	case rotated && p != nil:
+		p.add(ciliumCA.PlanEntry(defaults.CAName, generate.ActionUpdated, ciliumCA.RotationReason()))
+	case rotated:
+		ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
+		defer cancel()
+		if err := ciliumCA.Store(ctx, sink, true); err != nil {
+			return nil, 0, fmt.Errorf("failed to store rotated Cilium CA: %w", err)
 		}
-	}
-
-	var hubbleRelayServerCert *generate.Cert
-	if option.Config.HubbleRelayServerCertGenerate {
-		log.Info("Generating server certificates for Hubble Relay")
-		hubbleRelayServerCert = generate.NewCert(
-			option.Config.HubbleRelayServerCertCommonName,
-			option.Config.HubbleRelayServerCertValidityDuration,
-			defaults.HubbleRelayServerCertUsage,
-			option.Config.HubbleRelayServerCertSecretName,
-			option.Config.HubbleRelayServerCertSecretNamespace,
-		)
-		err := hubbleRelayServerCert.Generate(ciliumCA)
-		if err != nil {
-			return fmt.Errorf("failed to generate Hubble Relay server cert: %w", err)
-		}
-	}
-
-	var clustermeshApiserverServerCert *generate.Cert
-	if option.Config.ClustermeshApiserverServerCertGenerate {
-		log.Info("Generating server certificate for ClustermeshApiserver")
-		clustermeshApiserverServerCert = generate.NewCert(
-			option.Config.ClustermeshApiserverServerCertCommonName,
-			option.Config.ClustermeshApiserverServerCertValidityDuration,
-			defaults.ClustermeshApiserverCertUsage,
-			option.Config.ClustermeshApiserverServerCertSecretName,
-			option.Config.CiliumNamespace,
-		).WithHosts(
-			append([]string{
-				option.Config.ClustermeshApiserverServerCertCommonName,
-				"127.0.0.1",
-			}, option.Config.ClustermeshApiserverServerCertSANs...),
-		)
-		err = clustermeshApiserverServerCert.Generate(ciliumCA)
-		if err != nil {
-			return fmt.Errorf("failed to generate ClustermeshApiserver server cert: %w", err)
+		count++
+	case p != nil && ciliumCA.LoadedFromSecret():
+		p.add(ciliumCA.PlanEntry(defaults.CAName, generate.ActionKept, ""))
+	case p != nil && !option.Config.CAGenerate && !ciliumCA.IsEmpty() && !stored:
+		p.add(ciliumCA.PlanEntry(defaults.CAName, generate.ActionKept, keptReason))
+	case option.Config.CertManagerMode != "" && !stored:
+		// The existing secret may lack the keys read by the cert-manager CA
+		// issuer.
+		ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
+		defer cancel()
+		if err := ciliumCA.Store(ctx, sink, true); err != nil {
+			return nil, 0, fmt.Errorf("failed to store Cilium CA secret for cert-manager: %w", err)
 		}
 	}
 
-	var clustermeshApiserverAdminCert *generate.Cert
-	if option.Config.ClustermeshApiserverAdminCertGenerate {
-		log.Info("Generating admin certificate for ClustermeshApiserver")
-		clustermeshApiserverAdminCert = generate.NewCert(
-			option.Config.ClustermeshApiserverAdminCertCommonName,
-			option.Config.ClustermeshApiserverAdminCertValidityDuration,
-			defaults.ClustermeshApiserverCertUsage,
-			option.Config.ClustermeshApiserverAdminCertSecretName,
-			option.Config.CiliumNamespace,
-		).WithHosts([]string{"localhost"})
-		err = clustermeshApiserverAdminCert.Generate(ciliumCA)
-		if err != nil {
-			return fmt.Errorf("failed to generate ClustermeshApiserver admin cert: %w", err)
+	if p == nil {
+		switch {
+		case rotated:
+			r.AddCA(defaults.CAName, ciliumCA, generate.ActionUpdated, ciliumCA.RotationReason())
+		case stored:
+			r.AddCA(defaults.CAName, ciliumCA, generate.ActionCreated, "")
+		case ciliumCA.LoadedFromSecret():
+			r.AddCA(defaults.CAName, ciliumCA, generate.ActionKept, generate.ReasonLoadedFromSecret)
+		case !ciliumCA.IsEmpty():
+			r.AddCA(defaults.CAName, ciliumCA, generate.ActionKept, keptReason)
 		}
 	}
 
-	var clustermeshApiserverClientCert *generate.Cert
-	if option.Config.ClustermeshApiserverClientCertGenerate {
-		log.Info("Generating client certificate for ClustermeshApiserver")
-		clustermeshApiserverClientCert = generate.NewCert(
-			option.Config.ClustermeshApiserverClientCertCommonName,
-			option.Config.ClustermeshApiserverClientCertValidityDuration,
-			defaults.ClustermeshApiserverCertUsage,
-			option.Config.ClustermeshApiserverClientCertSecretName,
-			option.Config.CiliumNamespace,
-		)
-		err = clustermeshApiserverClientCert.Generate(ciliumCA)
-		if err != nil {
-			return fmt.Errorf("failed to generate ClustermeshApiserver client cert: %w", err)
+	cas := map[string]*generate.CA{defaults.CAName: ciliumCA}
+	specs := make(map[string]option.CASpec, len(option.Config.CAs))
+	parents := make(map[string]struct{})
+	for _, spec := range option.Config.CAs {
+		specs[spec.Name] = spec
+		if spec.Parent != "" {
+			parents[spec.Parent] = struct{}{}
 		}
 	}
 
-	var clustermeshApiserverRemoteCert *generate.Cert
-	if option.Config.ClustermeshApiserverRemoteCertGenerate {
-		log.Info("Generating remote certificate for ClustermeshApiserver")
-		clustermeshApiserverRemoteCert = generate.NewCert(
-			option.Config.ClustermeshApiserverRemoteCertCommonName,
-			option.Config.ClustermeshApiserverRemoteCertValidityDuration,
-			defaults.ClustermeshApiserverCertUsage,
-			option.Config.ClustermeshApiserverRemoteCertSecretName,
-			option.Config.CiliumNamespace,
-		)
-		err = clustermeshApiserverRemoteCert.Generate(ciliumCA)
-		if err != nil {
-			return fmt.Errorf("failed to generate ClustermeshApiserver remote cert: %w", err)
+	var loadCA func(name string) (*generate.CA, error)
+	loadCA = func(name string) (*generate.CA, error) {
+		if ca, ok := cas[name]; ok {
+			return ca, nil
 		}
-	}
-
-	if option.Config.HubbleServerCertGenerate {
 		ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
 		defer cancel()
-		if err := hubbleServerCert.StoreAsSecret(ctx, k8sClient); err != nil {
-			return fmt.Errorf("failed to create secret for Hubble server cert: %w", err)
+		ca, generated, err := loadOrGenerateCA(ctx, k8sClient, sink, specs[name], loadCA, p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load or generate CA %s: %w", name, err)
 		}
-		count++
-	}
-
-	if option.Config.HubbleMetricsServerCertGenerate {
-		ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
-		defer cancel()
-		if err := hubbleMetricsServerCert.StoreAsSecret(ctx, k8sClient); err != nil {
-			return fmt.Errorf("failed to create secret for Hubble server cert: %w", err)
+		if generated {
+			count++
 		}
-		count++
-	}
-
-	if option.Config.HubbleRelayClientCertGenerate {
-		ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
-		defer cancel()
-		if err := hubbleRelayClientCert.StoreAsSecret(ctx, k8sClient); err != nil {
-			return fmt.Errorf("failed to create secret for Hubble Relay client cert: %w", err)
+		if p == nil {
+			if generated {
+				r.AddCA(name, ca, generate.ActionCreated, "")
+			} else {
+				r.AddCA(name, ca, generate.ActionKept, generate.ReasonLoadedFromSecret)
+			}
 		}
-		count++
+		cas[name] = ca
+		return ca, nil
 	}
 
-	if option.Config.HubbleRelayServerCertGenerate {
-		ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
-		defer cancel()
-		if err := hubbleRelayServerCert.StoreAsSecret(ctx, k8sClient); err != nil {
-			return fmt.Errorf("failed to create secret for Hubble Relay server cert: %w", err)
+	// CAs signing intermediate CAs are only loaded when an intermediate CA
+	// needs to be generated, or if they directly issue certificates, so
+	// that their key can be kept offline otherwise.
+	for _, spec := range option.Config.CAs {
+		if _, ok := parents[spec.Name]; ok {
+			continue
+		}
+		if _, err := loadCA(spec.Name); err != nil {
+			return nil, 0, err
 		}
-		count++
 	}
-
-	if option.Config.ClustermeshApiserverServerCertGenerate {
-		ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
-		defer cancel()
-		if err := clustermeshApiserverServerCert.StoreAsSecret(ctx, k8sClient); err != nil {
-			return fmt.Errorf("failed to create secret for ClustermeshApiserver server cert: %w", err)
+	for _, spec := range option.Config.Certificates {
+		if _, err := loadCA(spec.CA); err != nil {
+			return nil, 0, err
 		}
-		count++
 	}
 
-	if option.Config.ClustermeshApiserverAdminCertGenerate {
-		ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
-		defer cancel()
-		if err := clustermeshApiserverAdminCert.StoreAsSecret(ctx, k8sClient); err != nil {
-			return fmt.Errorf("failed to create secret for ClustermeshApiserver admin cert: %w", err)
-		}
-		count++
+	return cas, count, nil
+}
+
+// checkStoredCA returns an error if the existing secret of ca holds a different
+// CA certificate.
+func checkStoredCA(k8sClient *kubernetes.Clientset, ca *generate.CA) error {
+	ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
+	defer cancel()
+
+	stored := generate.NewCA(ca.SecretName, ca.SecretNamespace)
+	if err := stored.LoadCertFromSecret(ctx, k8sClient); err != nil {
+		return fmt.Errorf("failed to load existing Cilium CA secret: %w", err)
 	}
+	if !stored.CACert.Equal(ca.CACert) {
+		return fmt.Errorf("secret %s/%s holds a different CA than %s, unset --%s to overwrite it",
+			ca.SecretNamespace, ca.SecretName, option.Config.CACertFile, option.CAReuseSecret)
+	}
+	log.Info("Cilium CA from file is already stored in its secret")
+	return nil
+}
 
-	if option.Config.ClustermeshApiserverClientCertGenerate {
-		ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
-		defer cancel()
-		if err := clustermeshApiserverClientCert.StoreAsSecret(ctx, k8sClient); err != nil {
-			return fmt.Errorf("failed to create secret for ClustermeshApiserver client cert: %w", err)
+// loadOrGenerateCA loads the CA described by spec from its secret, or generates
+// and stores it in sink if the secret does not exist yet. Intermediate CAs are signed
+// by the parent CA returned by loadParent. It returns whether the CA has been
+// generated. If p is not nil, the CA is not stored but the planned change is
+// recorded in p. If k8sClient is nil, the CA is always generated.
+func loadOrGenerateCA(
+	ctx context.Context,
+	k8sClient *kubernetes.Clientset,
+	sink generate.Sink,
+	spec option.CASpec,
+	loadParent func(name string) (*generate.CA, error),
+	p *planner,
+) (*generate.CA, bool, error) {
+	ca := spec.NewCA()
+	if k8sClient != nil {
+		err := ca.LoadFromSecret(ctx, k8sClient)
+		if err == nil {
+			log.WithField(logfields.CertName, spec.Name).Info("Loaded CA Secret")
+			if p != nil {
+				p.add(ca.PlanEntry(spec.Name, generate.ActionKept, ""))
+			}
+			return ca, false, nil
+		}
+		if !k8sErrors.IsNotFound(err) {
+			return nil, false, err
 		}
-		count++
 	}
 
-	if option.Config.ClustermeshApiserverRemoteCertGenerate {
-		ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
-		defer cancel()
-		if err := clustermeshApiserverRemoteCert.StoreAsSecret(ctx, k8sClient); err != nil {
-			return fmt.Errorf("failed to create secret for ClustermeshApiserver remote cert: %w", err)
+	var err error
+	if spec.Parent == "" {
+		err = ca.Generate(spec.CommonName, spec.ValidityDuration)
+	} else {
+		parent, perr := loadParent(spec.Parent)
+		if perr != nil {
+			return nil, false, perr
 		}
-		count++
+		if parent.IsEmpty() {
+			return nil, false, fmt.Errorf("parent CA %s is not available", spec.Parent)
+		}
+		err = ca.GenerateIntermediate(parent, spec.CommonName, spec.ValidityDuration)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if p != nil {
+		return ca, true, p.storeCA(ctx, k8sClient, spec.Name, ca, false)
+	}
+	if err := ca.Store(ctx, sink, false); err != nil {
+		return nil, false, err
 	}
+	return ca, true, nil
+}
 
-	log.Infof("Successfully generated all %d requested certificates.", count)
+// checkRenewal determines whether cert needs to be created, renewed, or can be
+// kept as stored in its existing secret.
+func checkRenewal(cert *generate.Cert, ca *generate.CA, renewBefore string, k8sClient *kubernetes.Clientset) (generate.Action, error) {
+	window, err := generate.ParseRenewBefore(renewBefore)
+	if err != nil {
+		return "", err
+	}
 
-	return nil
+	ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
+	defer cancel()
+	return cert.CheckRenewal(ctx, k8sClient, ca, window)
 }
@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+
+	"github.com/cilium/certgen/internal/defaults"
+	"github.com/cilium/certgen/internal/generate"
+	"github.com/cilium/certgen/internal/logging/logfields"
+	"github.com/cilium/certgen/internal/option"
+)
+
+// runCertManager emits or applies the cert-manager resources instead of
+// issuing the certificates. The Cilium CA secret, with the keys read by the
+// cert-manager CA issuer, is stored beforehand by loadCAs, or emitted first if
+// its manifest is in caManifests.
+func runCertManager(ciliumCA *generate.CA, caManifests []byte) error {
+	resources, err := certManagerResources(ciliumCA)
+	if err != nil {
+		return err
+	}
+
+	if option.Config.CertManagerMode == option.CertManagerModeEmit {
+		if _, err := os.Stdout.Write(caManifests); err != nil {
+			return err
+		}
+		return emitCertManagerResources(os.Stdout, resources)
+	}
+	config, err := k8sRestConfig(option.Config.K8sKubeConfigPath)
+	if err != nil {
+		return fmt.Errorf("failed initialize kubernetes client: %w", err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed initialize kubernetes dynamic client: %w", err)
+	}
+	return applyCertManagerResources(dynamicClient, resources)
+}
+
+// certManagerResources returns the cert-manager Issuer backed by the secret of
+// the Cilium CA, followed by a cert-manager Certificate per configured
+// certificate.
+func certManagerResources(ciliumCA *generate.CA) ([]*unstructured.Unstructured, error) {
+	issuer, err := ciliumCA.CertManagerIssuer(option.Config.CertManagerIssuerName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cert-manager Issuer: %w", err)
+	}
+
+	resources := []*unstructured.Unstructured{issuer}
+	for _, spec := range option.Config.Certificates {
+		if spec.CA != defaults.CAName {
+			return nil, fmt.Errorf("%s cert is issued by CA %s, but the cert-manager Issuer is backed by the Cilium CA",
+				spec.Name, spec.CA)
+		}
+		if spec.SecretNamespace != ciliumCA.SecretNamespace {
+			return nil, fmt.Errorf("%s cert is stored in namespace %s, but the cert-manager Issuer can only issue certificates in namespace %s",
+				spec.Name, spec.SecretNamespace, ciliumCA.SecretNamespace)
+		}
+		renewBefore, err := generate.ParseRenewBefore(spec.RenewBefore)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := spec.NewCert().CertManagerCertificate(option.Config.CertManagerIssuerName, renewBefore)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cert-manager Certificate for %s cert: %w", spec.Name, err)
+		}
+		resources = append(resources, cert)
+	}
+	return resources, nil
+}
+
+// emitCertManagerResources writes the cert-manager resources to w as
+// multi-document YAML manifests.
+func emitCertManagerResources(w io.Writer, resources []*unstructured.Unstructured) error {
+	for _, res := range resources {
+		out, err := yaml.Marshal(res.Object)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s %s/%s: %w", res.GetKind(), res.GetNamespace(), res.GetName(), err)
+		}
+		if _, err := fmt.Fprintf(w, "---\n%s", out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyCertManagerResources applies the cert-manager resources with the
+// dynamic client, so that the cert-manager types need not be known.
+func applyCertManagerResources(dynamicClient dynamic.Interface, resources []*unstructured.Unstructured) error {
+	for _, res := range resources {
+		gvr := generate.CertificateResource
+		if res.GetKind() == "Issuer" {
+			gvr = generate.IssuerResource
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
+		_, err := dynamicClient.Resource(gvr).Namespace(res.GetNamespace()).Apply(ctx, res.GetName(), res, meta_v1.ApplyOptions{
+			FieldManager: generate.FieldManager,
+			Force:        option.Config.ForceConflicts,
+		})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to apply cert-manager %s %s/%s: %w", res.GetKind(), res.GetNamespace(), res.GetName(), err)
+		}
+		log.WithFields(logrus.Fields{
+			logfields.K8sKind:   res.GetKind(),
+			logfields.K8sObject: res.GetNamespace() + "/" + res.GetName(),
+		}).Info("Applied cert-manager resource")
+	}
+	return nil
+}
+
+// emitSink emits the secrets as manifests instead of storing them. The
+// secrets which must not be overwritten are only emitted if they do not exist
+// yet, so that existing CA secrets are reused as when storing them.
+type emitSink struct {
+	generate.Sink
+	k8sClient *kubernetes.Clientset
+}
+
+// Store implements generate.Sink
+func (s emitSink) Store(ctx context.Context, secret *v1.Secret, force bool) error {
+	if !force {
+		_, err := s.k8sClient.CoreV1().Secrets(secret.Namespace).Get(ctx, secret.Name, meta_v1.GetOptions{})
+		if err == nil {
+			return k8sErrors.NewAlreadyExists(v1.Resource("secrets"), secret.Name)
+		}
+		if !k8sErrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return s.Sink.Store(ctx, secret, force)
+}
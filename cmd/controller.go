@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/signal"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/cilium/certgen/internal/controller"
+	"github.com/cilium/certgen/internal/defaults"
+	"github.com/cilium/certgen/internal/events"
+	"github.com/cilium/certgen/internal/generate"
+	"github.com/cilium/certgen/internal/logging"
+	"github.com/cilium/certgen/internal/option"
+	"github.com/cilium/certgen/internal/version"
+)
+
+// newControllerCmd creates the command running certgen as a long-running
+// controller.
+func newControllerCmd(vp *viper.Viper) *cobra.Command {
+	return &cobra.Command{
+		Use:   "controller [flags]",
+		Short: "Run as a controller keeping the certificates renewed",
+		Long: binaryName + " controller watches the certificate secrets, renews the certificates " +
+			"before they expire and re-creates the secrets which are deleted or corrupted. It can also " +
+			"sign K8s CertificateSigningRequests with the Cilium CA.",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := option.Config.PopulateFrom(vp); err != nil {
+				log.WithError(err).Fatal("failed to load configuration")
+			}
+			if err := option.Config.ValidateRenewBefore(); err != nil {
+				log.WithError(err).Fatal("failed to load configuration")
+			}
+
+			if option.Config.Debug {
+				logging.DefaultLogger.SetLevel(logrus.DebugLevel)
+			}
+
+			log.Infof("%s %s", binaryName, version.Version)
+
+			ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+
+			if err := runController(ctx); err != nil {
+				log.WithError(err).Fatal("failed to run controller")
+			}
+		},
+	}
+}
+
+// runController loads the CAs and runs the certificate controller until ctx
+// is canceled
+func runController(ctx context.Context) error {
+	if option.Config.DryRun {
+		return errors.New("dry-run mode is not supported by the controller")
+	}
+	if option.Config.CertManagerMode != "" {
+		return errors.New("cert-manager mode is not supported by the controller")
+	}
+
+	k8sClient, err := k8sConfig(option.Config.K8sKubeConfigPath)
+	if err != nil {
+		return fmt.Errorf("failed initialize kubernetes client: %w", err)
+	}
+
+	// The Lease is held while loading the CAs, and then during each
+	// reconciliation rather than for the lifetime of the controller, so that
+	// certgen Jobs using the same Lease can still run alongside it.
+	_, release, err := acquireLock(k8sClient)
+	if err != nil {
+		return err
+	}
+	sink := generate.NewSecretSink(k8sClient).WithForceConflicts(option.Config.ForceConflicts)
+	cas, _, err := loadCAs(k8sClient, sink, nil, nil)
+	release()
+	if err != nil {
+		return err
+	}
+
+	// The CAs stored in secrets are reloaded when their secret changes, so
+	// that the CAs rotated or regenerated by certgen Jobs are followed.
+	var reload []string
+	for name, ca := range cas {
+		if name != defaults.CAName || (ca.SecretName != "" && !ca.IsExternal() &&
+			(ca.LoadedFromSecret() || option.Config.CAGenerate || option.Config.CAStoreFile)) {
+			reload = append(reload, name)
+		}
+	}
+
+	c := controller.New(k8sClient, sink, cas, option.Config.Certificates, option.Config.K8sRequestTimeout).
+		WithCAReload(reload)
+	if option.Config.LeaseLock {
+		c.WithLock(func() (context.Context, func(), error) { return acquireLock(k8sClient) })
+	}
+	if option.Config.Events {
+		// The controller emits no run events, so its pod is not looked up.
+		recorder := events.NewRecorder(k8sClient, binaryName, false, option.Config.K8sRequestTimeout)
+		defer recorder.Shutdown(option.Config.K8sRequestTimeout)
+		c.WithRecorder(recorder)
+	}
+	if option.Config.CASignerName == "" {
+		return c.Run(ctx)
+	}
+
+	passphrase, err := caKeyPassphrase(k8sClient)
+	if err != nil {
+		return err
+	}
+	ca := generate.NewCA(option.Config.CASecretName, option.Config.CASecretNamespace).WithKeyPassphrase(passphrase, false)
+	s := controller.NewSigner(k8sClient, option.Config.CASignerName, ca, option.Config.CASigningPolicy(), option.Config.K8sRequestTimeout)
+
+	// Stop both the certificate controller and the signer if either fails.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, 2)
+	go func() { errs <- c.Run(ctx) }()
+	go func() { errs <- s.Run(ctx) }()
+	err = <-errs
+	cancel()
+	return errors.Join(err, <-errs)
+}
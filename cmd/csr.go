@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/cilium/certgen/internal/generate"
+	"github.com/cilium/certgen/internal/logging/logfields"
+	"github.com/cilium/certgen/internal/option"
+)
+
+// csrCABundle returns the CA bundle of the CSR signer read from the configured
+// K8s ConfigMap or Secret.
+func csrCABundle(k8sClient *kubernetes.Clientset) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
+	defer cancel()
+
+	namespace, key := option.Config.CSRCABundleNamespace, option.Config.CSRCABundleKey
+	var bundle []byte
+	var source string
+	if name := option.Config.CSRCABundleConfigMapName; name != "" {
+		cm, err := k8sClient.CoreV1().ConfigMaps(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get CA bundle configmap %s/%s: %w", namespace, name, err)
+		}
+		if data, ok := cm.Data[key]; ok {
+			bundle = []byte(data)
+		} else {
+			bundle = cm.BinaryData[key]
+		}
+		source = fmt.Sprintf("key %s of configmap %s/%s", key, namespace, name)
+	} else {
+		name := option.Config.CSRCABundleSecretName
+		secret, err := k8sClient.CoreV1().Secrets(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get CA bundle secret %s/%s: %w", namespace, name, err)
+		}
+		bundle = secret.Data[key]
+		source = fmt.Sprintf("key %s of secret %s/%s", key, namespace, name)
+	}
+
+	if len(bundle) == 0 {
+		return nil, fmt.Errorf("CA bundle from %s is empty", source)
+	}
+	return bundle, nil
+}
+
+// loadCSRSignerCA loads the CA bundle of the configured CSR signer into ca,
+// which then issues its certificates through K8s CertificateSigningRequests.
+func loadCSRSignerCA(k8sClient *kubernetes.Clientset, ca *generate.CA) error {
+	if k8sClient == nil {
+		return errors.New("certificates cannot be issued through K8s CertificateSigningRequests without K8s access")
+	}
+	bundle, err := csrCABundle(k8sClient)
+	if err != nil {
+		return err
+	}
+
+	s := generate.NewCSRSigner(k8sClient, option.Config.CSRSignerName, option.Config.CSRAutoApprove,
+		option.Config.CSRTimeout, option.Config.K8sRequestTimeout)
+	if err := ca.LoadFromCSRSigner(s, bundle); err != nil {
+		return err
+	}
+	log.WithField(logfields.K8sSignerName, s.SignerName()).Info("Loaded CA bundle of K8s CertificateSigningRequest signer")
+	return nil
+}
@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package cmd
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/cilium/certgen/internal/events"
+	"github.com/cilium/certgen/internal/generate"
+	"github.com/cilium/certgen/internal/option"
+)
+
+// emitEvents emits the K8s Events describing the outcome of the run reported
+// in r: one event per CA and certificate secret written or reused, or an
+// IssuanceFailed event on every certificate secret if the run failed, and a
+// summary event on the certgen Job or Pod.
+func emitEvents(recorder *events.Recorder, r *generate.Report) {
+	if !r.Success {
+		message := "Failed to issue certificates: " + r.Error
+		for _, spec := range option.Config.Certificates {
+			recorder.Secret(spec.SecretNamespace, spec.SecretName, v1.EventTypeWarning, events.ReasonIssuanceFailed, message)
+		}
+		recorder.Run(v1.EventTypeWarning, events.ReasonIssuanceFailed, message)
+		return
+	}
+
+	for _, e := range r.CAs {
+		recorder.CA(e)
+	}
+
+	counts := make(map[generate.Action]int)
+	for _, e := range r.Certificates {
+		counts[e.Action]++
+		recorder.Certificate(e)
+	}
+
+	recorder.Run(v1.EventTypeNormal, events.ReasonIssuanceSucceeded,
+		fmt.Sprintf("Processed %d certificates: %d issued, %d renewed, %d updated, %d kept",
+			len(r.Certificates), counts[generate.ActionCreated], counts[generate.ActionRenewed],
+			counts[generate.ActionUpdated], counts[generate.ActionKept]))
+}
@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/cilium/certgen/internal/defaults"
+	"github.com/cilium/certgen/internal/generate"
+	"github.com/cilium/certgen/internal/logging"
+	"github.com/cilium/certgen/internal/option"
+	"github.com/cilium/certgen/internal/version"
+)
+
+// newInspectCmd creates the command reporting the health of the CAs and
+// certificates managed by certgen.
+func newInspectCmd(vp *viper.Viper) *cobra.Command {
+	return &cobra.Command{
+		Use:     "inspect [flags]",
+		Aliases: []string{"status"},
+		Short:   "Report the CAs and certificates stored by certgen and their health",
+		Long: binaryName + " inspect reads the CA and certificate secrets, and reports their details " +
+			"and whether they verify against the current CA. It exits with a non-zero code if any " +
+			"certificate is missing, invalid, expired or about to expire.",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := option.Config.PopulateFrom(vp); err != nil {
+				log.WithError(err).Fatal("failed to load configuration")
+			}
+
+			if option.Config.Debug {
+				logging.DefaultLogger.SetLevel(logrus.DebugLevel)
+			}
+
+			log.Debugf("%s %s", binaryName, version.Version)
+
+			unhealthy, err := inspectCertificates(os.Stdout)
+			if err != nil {
+				log.WithError(err).Fatal("failed to inspect certificates")
+			}
+			if unhealthy > 0 {
+				log.Fatalf("%d certificates are missing, invalid, untrusted, expired or about to expire", unhealthy)
+			}
+		},
+	}
+}
+
+// inspectCertificates inspects the configured CAs and certificates, prints the
+// report to w and returns the number of unhealthy ones.
+func inspectCertificates(w io.Writer) (int, error) {
+	k8sClient, err := k8sConfig(option.Config.K8sKubeConfigPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed initialize kubernetes client: %w", err)
+	}
+	warnBefore, err := generate.ParseRenewBefore(option.Config.InspectWarnBefore)
+	if err != nil {
+		return 0, err
+	}
+	inspector := generate.NewInspector(k8sClient, warnBefore)
+
+	ciliumCA := generate.NewCA(option.Config.CASecretName, option.Config.CASecretNamespace)
+	if option.Config.RemoteSignerURL != "" {
+		// The Cilium CA is the CA of the remote signer.
+		ciliumCA = generate.NewCA("", "")
+		if err := loadRemoteCA(ciliumCA); err != nil {
+			return 0, fmt.Errorf("failed to load Cilium CA from remote signer: %w", err)
+		}
+	} else if option.Config.CSRSignerName != "" {
+		// The Cilium CA is the CA bundle of the CSR signer.
+		ciliumCA = generate.NewCA("", "")
+		if err := loadCSRSignerCA(k8sClient, ciliumCA); err != nil {
+			return 0, fmt.Errorf("failed to load Cilium CA bundle of CSR signer: %w", err)
+		}
+	} else if !option.Config.CAGenerate && option.Config.CACertFile != "" {
+		// The Cilium CA is not stored in a secret.
+		passphrase, err := caKeyPassphrase(k8sClient)
+		if err != nil {
+			return 0, err
+		}
+		ciliumCA = generate.NewCA("", "").WithKeyPassphrase(passphrase, false)
+		if err := ciliumCA.LoadFromFile(option.Config.CACertFile, option.Config.CAKeyFile); err != nil {
+			return 0, fmt.Errorf("failed to load Cilium CA from file: %w", err)
+		}
+	}
+	cas := map[string]*generate.CA{defaults.CAName: ciliumCA}
+	for _, spec := range option.Config.CAs {
+		cas[spec.Name] = generate.NewCA(spec.SecretName, spec.SecretNamespace)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
+	defer cancel()
+	report := []generate.Inspection{inspector.InspectCA(ctx, defaults.CAName, ciliumCA)}
+	for _, spec := range option.Config.CAs {
+		ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
+		report = append(report, inspector.InspectCA(ctx, spec.Name, cas[spec.Name]))
+		cancel()
+	}
+	for _, spec := range option.Config.Certificates {
+		ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
+		report = append(report, inspector.InspectCert(ctx, spec.Name, spec.NewCert(), cas[spec.CA]))
+		cancel()
+	}
+
+	if err := printInspection(w, report, option.Config.InspectOutput); err != nil {
+		return 0, fmt.Errorf("failed to print report: %w", err)
+	}
+
+	unhealthy := 0
+	for _, entry := range report {
+		if !entry.Healthy() {
+			unhealthy++
+		}
+	}
+	return unhealthy, nil
+}
+
+// printInspection writes the report to w, either as a human-readable table or
+// as JSON.
+func printInspection(w io.Writer, report []generate.Inspection, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tNAME\tSECRET\tSTATUS\tNOT BEFORE\tNOT AFTER\tREMAINING\tCHAIN\tISSUER\tKEY\tSUBJECT\tHOSTS\tUSAGES")
+	for _, e := range report {
+		secret := "-"
+		if e.SecretName != "" {
+			secret = e.SecretNamespace + "/" + e.SecretName
+		}
+		chain := ""
+		switch {
+		case e.ChainVerified:
+			chain = "verified"
+		case e.NotAfter != nil:
+			chain = "unverified"
+		}
+		issuer := e.IssuerFingerprint
+		if len(issuer) > 16 {
+			issuer = issuer[:16]
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Kind, e.Name, secret, e.Status,
+			formatTime(e.NotBefore), formatTime(e.NotAfter), e.Remaining, chain, issuer, e.KeyType, e.Subject,
+			strings.Join(e.Hosts, ","), strings.Join(e.Usages, ","))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, e := range report {
+		if e.Error != "" {
+			fmt.Fprintf(w, "%s %s: %s\n", e.Kind, e.Name, e.Error)
+		}
+	}
+	return nil
+}
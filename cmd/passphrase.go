@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/cilium/certgen/internal/option"
+)
+
+// caKeyPassphrase returns the passphrase of the Cilium CA key read from the
+// configured file, environment variable or K8s Secret, or nil if none is
+// configured. Trailing newlines are trimmed from the file and Secret values.
+func caKeyPassphrase(k8sClient *kubernetes.Clientset) ([]byte, error) {
+	var passphrase []byte
+	var source string
+	switch {
+	case option.Config.CAKeyPassphraseFile != "":
+		data, err := os.ReadFile(option.Config.CAKeyPassphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA key passphrase file: %w", err)
+		}
+		passphrase = bytes.TrimRight(data, "\r\n")
+		source = "file " + option.Config.CAKeyPassphraseFile
+
+	case option.Config.CAKeyPassphraseEnv != "":
+		passphrase = []byte(os.Getenv(option.Config.CAKeyPassphraseEnv))
+		source = "environment variable " + option.Config.CAKeyPassphraseEnv
+
+	case option.Config.CAKeyPassphraseSecretName != "":
+		if k8sClient == nil {
+			return nil, errors.New("CA key passphrase cannot be read from a K8s Secret without K8s access")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
+		defer cancel()
+		namespace, name := option.Config.CAKeyPassphraseSecretNamespace, option.Config.CAKeyPassphraseSecretName
+		secret, err := k8sClient.CoreV1().Secrets(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get CA key passphrase secret %s/%s: %w", namespace, name, err)
+		}
+		passphrase = bytes.TrimRight(secret.Data[option.Config.CAKeyPassphraseSecretKey], "\r\n")
+		source = fmt.Sprintf("key %s of secret %s/%s", option.Config.CAKeyPassphraseSecretKey, namespace, name)
+
+	default:
+		return nil, nil
+	}
+
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("CA key passphrase from %s is empty", source)
+	}
+	return passphrase, nil
+}
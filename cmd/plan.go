@@ -0,0 +1,150 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/cilium/certgen/internal/generate"
+	"github.com/cilium/certgen/internal/option"
+)
+
+// planner records the changes certgen would make to the CA and certificate
+// secrets in dry-run mode, instead of writing them.
+type planner struct {
+	entries []generate.PlanEntry
+}
+
+// add records the planned change to a secret.
+func (p *planner) add(entry generate.PlanEntry) {
+	p.entries = append(p.entries, entry)
+}
+
+// storeCA plans storing ca in its secret, mimicking generate.SecretSink.Store
+// without writing anything: if the secret already exists and force is false,
+// it returns the same IsAlreadyExists error.
+func (p *planner) storeCA(ctx context.Context, k8sClient *kubernetes.Clientset, name string, ca *generate.CA, force bool) error {
+	_, err := k8sClient.CoreV1().Secrets(ca.SecretNamespace).Get(ctx, ca.SecretName, meta_v1.GetOptions{})
+	switch {
+	case k8sErrors.IsNotFound(err):
+		p.add(ca.PlanEntry(name, generate.ActionCreated, ""))
+		return nil
+	case err != nil:
+		return err
+	case !force:
+		return k8sErrors.NewAlreadyExists(schema.GroupResource{Resource: "secrets"}, ca.SecretName)
+	}
+	p.add(ca.PlanEntry(name, generate.ActionRenewed, "existing secret is overwritten"))
+	return nil
+}
+
+// changes returns the number of secrets which would be modified.
+func (p *planner) changes() int {
+	count := 0
+	for _, entry := range p.entries {
+		if entry.Changed() {
+			count++
+		}
+	}
+	return count
+}
+
+// print writes the plan to w, either as a human-readable table or as JSON.
+func (p *planner) print(w io.Writer, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(p.entries)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tNAME\tSECRET\tACTION\tEXPIRY\tDETAILS")
+	for _, e := range p.entries {
+		secret := "-"
+		if e.SecretName != "" {
+			secret = e.SecretNamespace + "/" + e.SecretName
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Kind, e.Name, secret,
+			e.Action, planChange(formatTime(e.CurrentNotAfter), formatTime(e.NotAfter)), planDetails(e))
+	}
+	return tw.Flush()
+}
+
+// planDetails returns the human-readable reason and diff of a plan entry.
+func planDetails(e generate.PlanEntry) string {
+	var details []string
+	if e.Reason != "" {
+		details = append(details, e.Reason)
+	}
+	if e.IssuerChanged() {
+		current, planned := e.CurrentIssuer, e.Issuer
+		if current == planned {
+			// Tell apart the CAs with the same common name by key ID.
+			current += " (key ID " + e.CurrentIssuerKeyID + ")"
+			planned += " (key ID " + e.IssuerKeyID + ")"
+		}
+		details = append(details, "issuer: "+planChange(current, planned))
+	}
+	for _, host := range e.AddedHosts {
+		details = append(details, "+"+host)
+	}
+	for _, host := range e.RemovedHosts {
+		details = append(details, "-"+host)
+	}
+	return strings.Join(details, "; ")
+}
+
+// planChange formats the change from the current to the planned value.
+func planChange(current, planned string) string {
+	if current == "" || current == planned {
+		return planned
+	}
+	return current + " -> " + planned
+}
+
+// formatTime formats t, if set.
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// planCertificates records the planned changes to the certificate secrets,
+// issued by cas, and prints the whole plan to stdout.
+func planCertificates(k8sClient *kubernetes.Clientset, cas map[string]*generate.CA, p *planner) error {
+	for _, spec := range option.Config.Certificates {
+		renewBefore, err := generate.ParseRenewBefore(spec.RenewBefore)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
+		defer cancel()
+		entry, err := spec.NewCert().Plan(ctx, k8sClient, cas[spec.CA], renewBefore, option.Config.CertReuseSecret)
+		if err != nil {
+			return fmt.Errorf("failed to plan %s cert: %w", spec.Name, err)
+		}
+		entry.Name = spec.Name
+		p.add(entry)
+	}
+
+	if err := p.print(os.Stdout, option.Config.DryRunOutput); err != nil {
+		return fmt.Errorf("failed to print plan: %w", err)
+	}
+	log.Infof("Dry run completed, %d secrets would be changed.", p.changes())
+	return nil
+}
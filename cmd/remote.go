@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package cmd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/sirupsen/logrus"
+
+	"github.com/cilium/certgen/internal/generate"
+	"github.com/cilium/certgen/internal/logging/logfields"
+	"github.com/cilium/certgen/internal/option"
+)
+
+// newRemoteSigner creates the remote signer configured by the remote signer
+// options.
+func newRemoteSigner() (*generate.RemoteSigner, error) {
+	var tlsConfig *tls.Config
+	if option.Config.RemoteSignerTLSCAFile != "" {
+		pool, err := helpers.LoadPEMCertPool(option.Config.RemoteSignerTLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load remote signer TLS CA file: %w", err)
+		}
+		tlsConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	var authKey string
+	if option.Config.RemoteSignerAuthKeyFile != "" {
+		data, err := os.ReadFile(option.Config.RemoteSignerAuthKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read remote signer auth key file: %w", err)
+		}
+		authKey = strings.TrimSpace(string(data))
+	}
+
+	return generate.NewRemoteSigner(option.Config.RemoteSignerURL, tlsConfig, option.Config.RemoteSignerTimeout,
+		option.Config.RemoteSignerProfile, option.Config.RemoteSignerLabel, authKey)
+}
+
+// loadRemoteCA loads the CA of the configured remote signer into ca.
+func loadRemoteCA(ca *generate.CA) error {
+	s, err := newRemoteSigner()
+	if err != nil {
+		return err
+	}
+
+	var chainBytes []byte
+	if option.Config.RemoteSignerCAChainFile != "" {
+		chainBytes, err = os.ReadFile(option.Config.RemoteSignerCAChainFile)
+		if err != nil {
+			return fmt.Errorf("failed to read remote signer CA chain file: %w", err)
+		}
+	}
+
+	if err := ca.LoadFromRemote(s, chainBytes); err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		logfields.RemoteSignerHosts: s.Hosts(),
+		logfields.CertCommonName:    ca.CACert.Subject.CommonName,
+	}).Info("Loaded CA from remote signer")
+	return nil
+}
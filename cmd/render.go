@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/cilium/certgen/internal/generate"
+	"github.com/cilium/certgen/internal/logging"
+	"github.com/cilium/certgen/internal/option"
+	"github.com/cilium/certgen/internal/version"
+)
+
+// newRenderCmd creates the command rendering the CA and certificate secrets as
+// K8s manifests, without accessing the cluster.
+func newRenderCmd(vp *viper.Viper) *cobra.Command {
+	return &cobra.Command{
+		Use:   "render [flags]",
+		Short: "Render the CA and certificate secrets as K8s manifests",
+		Long: binaryName + " render generates the CA and certificates offline and writes them " +
+			"as a multi-document YAML stream of K8s Secrets, without accessing the cluster",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := option.Config.PopulateFrom(vp); err != nil {
+				log.WithError(err).Fatal("failed to load configuration")
+			}
+
+			if option.Config.Debug {
+				logging.DefaultLogger.SetLevel(logrus.DebugLevel)
+			}
+
+			log.Infof("%s %s", binaryName, version.Version)
+
+			if err := renderCertificates(); err != nil {
+				log.WithError(err).Fatal("failed to render certificates")
+			}
+		},
+	}
+}
+
+// renderCertificates generates the CAs and certificates and writes their
+// secrets to the render output. A CA loaded from file is not rendered, as
+// certgen does not store it either.
+func renderCertificates() error {
+	for _, o := range []struct {
+		name string
+		set  bool
+	}{
+		{option.DryRun, option.Config.DryRun},
+		{option.CAReuseSecret, option.Config.CAReuseSecret},
+		{option.CARotate, option.Config.CARotate},
+		{option.CertReuseSecret, option.Config.CertReuseSecret},
+		{option.CSRSignerName, option.Config.CSRSignerName != ""},
+		{option.CertManagerMode, option.Config.CertManagerMode != ""},
+	} {
+		if o.set {
+			return fmt.Errorf("%s requires cluster access and is not supported by render", o.name)
+		}
+	}
+	if !option.Config.CAGenerate && option.Config.RemoteSignerURL == "" &&
+		(option.Config.CACertFile == "" || option.Config.CAKeyFile == "") {
+		return errors.New("render requires either a generated Cilium CA, a Cilium CA cert and key file or a remote signer")
+	}
+
+	var w io.Writer = os.Stdout
+	if option.Config.RenderOutput != "" {
+		// The manifests contain private keys, only the owner may read them.
+		f, err := os.OpenFile(option.Config.RenderOutput, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to open render output: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	sink := generate.NewManifestSink(w)
+	cas, count, err := loadCAs(nil, sink, nil, nil)
+	if err != nil {
+		return err
+	}
+	stored, err := storeCertificates(nil, cas, sink, nil)
+	if err != nil {
+		return err
+	}
+	count += stored
+
+	if f, ok := w.(*os.File); ok && f != os.Stdout {
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to write render output: %w", err)
+		}
+	}
+
+	log.Infof("Successfully rendered all %d requested secrets.", count)
+	return nil
+}
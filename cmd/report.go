@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/cilium/certgen/internal/generate"
+	"github.com/cilium/certgen/internal/logging/logfields"
+	"github.com/cilium/certgen/internal/option"
+)
+
+// reportKey is the key of the ConfigMap holding the JSON run report.
+const reportKey = "report.json"
+
+// reportEnabled returns true if the run report is written to a file or
+// ConfigMap.
+func reportEnabled() bool {
+	return option.Config.ReportOutput != "" || option.Config.ReportConfigMapName != ""
+}
+
+// writeReport writes r as JSON to the file and/or ConfigMap configured by the
+// report options.
+func writeReport(k8sClient *kubernetes.Clientset, r *generate.Report) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	data = append(data, '\n')
+
+	switch option.Config.ReportOutput {
+	case "":
+	case "-":
+		if _, err := os.Stdout.Write(data); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+	default:
+		if err := os.WriteFile(option.Config.ReportOutput, data, 0o600); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+		log.WithField(logfields.Path, option.Config.ReportOutput).Info("Wrote run report")
+	}
+
+	if option.Config.ReportConfigMapName != "" {
+		if err := storeReportConfigMap(k8sClient, data); err != nil {
+			return fmt.Errorf("failed to store report in ConfigMap %s/%s: %w",
+				option.Config.ReportConfigMapNamespace, option.Config.ReportConfigMapName, err)
+		}
+	}
+	return nil
+}
+
+// storeReportConfigMap applies the ConfigMap holding the JSON run report data.
+func storeReportConfigMap(k8sClient *kubernetes.Clientset, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
+	defer cancel()
+
+	namespace, name := option.Config.ReportConfigMapNamespace, option.Config.ReportConfigMapName
+	configMap := corev1ac.ConfigMap(name, namespace).
+		WithLabels(map[string]string{generate.LabelManagedBy: generate.ManagedBy}).
+		WithData(map[string]string{reportKey: string(data)})
+	_, err := k8sClient.CoreV1().ConfigMaps(namespace).Apply(ctx, configMap, meta_v1.ApplyOptions{
+		FieldManager: generate.FieldManager,
+		Force:        true,
+	})
+	if err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		logfields.K8sConfigMapNamespace: namespace,
+		logfields.K8sConfigMapName:      name,
+	}).Info("Stored run report in K8s ConfigMap")
+	return nil
+}
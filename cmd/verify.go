@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/cilium/certgen/internal/defaults"
+	"github.com/cilium/certgen/internal/generate"
+	"github.com/cilium/certgen/internal/logging"
+	"github.com/cilium/certgen/internal/logging/logfields"
+	"github.com/cilium/certgen/internal/option"
+	"github.com/cilium/certgen/internal/version"
+)
+
+// newVerifyCmd creates the command verifying the certificate secrets against
+// the current CAs and the configured certificates.
+func newVerifyCmd(vp *viper.Viper) *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify [flags]",
+		Short: "Verify the certificate secrets against the current CA and their configuration",
+		Long: binaryName + " verify checks that the key of each configured certificate secret matches " +
+			"its certificate, that the certificate chains to the CA bundle of the secret and to the " +
+			"current CA, and that its SANs and extended key usages match the configuration. " +
+			"It exits with a non-zero code if any check fails.",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := option.Config.PopulateFrom(vp); err != nil {
+				log.WithError(err).Fatal("failed to load configuration")
+			}
+
+			if option.Config.Debug {
+				logging.DefaultLogger.SetLevel(logrus.DebugLevel)
+			}
+
+			log.Debugf("%s %s", binaryName, version.Version)
+
+			failed, err := verifyCertificates(os.Stdout)
+			if err != nil {
+				log.WithError(err).Fatal("failed to verify certificates")
+			}
+			if failed > 0 {
+				log.Fatalf("%d certificate secrets failed verification", failed)
+			}
+		},
+	}
+}
+
+// verifyCertificates verifies the configured certificate secrets, prints the
+// failed checks of each secret to w and returns the number of secrets failing
+// verification.
+func verifyCertificates(w io.Writer) (int, error) {
+	k8sClient, err := k8sConfig(option.Config.K8sKubeConfigPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed initialize kubernetes client: %w", err)
+	}
+
+	// Only the CA certificates are needed, so that CAs whose key is kept
+	// offline can be verified against too.
+	cas := make(map[string]*generate.CA)
+	loadCA := func(name string) *generate.CA {
+		if ca, ok := cas[name]; ok {
+			return ca
+		}
+		ca := generate.NewCA(option.Config.CASecretName, option.Config.CASecretNamespace)
+		for _, spec := range option.Config.CAs {
+			if spec.Name == name {
+				ca = generate.NewCA(spec.SecretName, spec.SecretNamespace)
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
+		defer cancel()
+		if name == defaults.CAName && option.Config.RemoteSignerURL != "" {
+			err = loadRemoteCA(ca)
+		} else if name == defaults.CAName && option.Config.CSRSignerName != "" {
+			err = loadCSRSignerCA(k8sClient, ca)
+		} else if name == defaults.CAName && !option.Config.CAGenerate && option.Config.CACertFile != "" {
+			var passphrase []byte
+			passphrase, err = caKeyPassphrase(k8sClient)
+			if err == nil {
+				err = ca.WithKeyPassphrase(passphrase, false).LoadFromFile(option.Config.CACertFile, option.Config.CAKeyFile)
+			}
+		} else {
+			err = ca.LoadCertFromSecret(ctx, k8sClient)
+		}
+		if err != nil {
+			log.WithError(err).WithField(logfields.CertName, name).Warn("Failed to load CA")
+			ca = nil
+		}
+		cas[name] = ca
+		return ca
+	}
+
+	failed := 0
+	for _, spec := range option.Config.Certificates {
+		ca := loadCA(spec.CA)
+		ctx, cancel := context.WithTimeout(context.Background(), option.Config.K8sRequestTimeout)
+		failures, err := spec.NewCert().Verify(ctx, k8sClient, ca)
+		cancel()
+		if err != nil {
+			return 0, fmt.Errorf("failed to verify %s cert: %w", spec.Name, err)
+		}
+
+		if len(failures) == 0 {
+			fmt.Fprintf(w, "OK    %s (%s/%s)\n", spec.Name, spec.SecretNamespace, spec.SecretName)
+			continue
+		}
+		failed++
+		fmt.Fprintf(w, "FAIL  %s (%s/%s)\n", spec.Name, spec.SecretNamespace, spec.SecretName)
+		for _, failure := range failures {
+			fmt.Fprintf(w, "      - %s\n", failure)
+		}
+	}
+	return failed, nil
+}
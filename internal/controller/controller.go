@@ -0,0 +1,361 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/cilium/certgen/internal/defaults"
+	"github.com/cilium/certgen/internal/events"
+	"github.com/cilium/certgen/internal/generate"
+	"github.com/cilium/certgen/internal/logging"
+	"github.com/cilium/certgen/internal/logging/logfields"
+	"github.com/cilium/certgen/internal/option"
+)
+
+var (
+	log = logging.DefaultLogger.WithField(logfields.LogSubsys, "controller")
+)
+
+// Controller keeps the certificates described by a set of specs renewed. It
+// watches their K8s secrets, schedules the renewal of each certificate based
+// on its expiry, and re-creates the secrets which are deleted or corrupted.
+type Controller struct {
+	k8sClient      *kubernetes.Clientset
+	sink           generate.Sink
+	cas            map[string]*generate.CA
+	specs          map[string]option.CertificateSpec
+	requestTimeout time.Duration
+
+	// reload holds the names of the CAs reloaded from their secret.
+	reload map[string]struct{}
+	// lock acquires the lock held while reconciling, if any.
+	lock func() (context.Context, func(), error)
+	// recorder emits the K8s Events of the reconciliations, if any.
+	recorder *events.Recorder
+
+	queue workqueue.RateLimitingInterface
+}
+
+// caKey identifies a CA in the work queue, by name.
+type caKey string
+
+// New creates a new controller for the certificates described by specs,
+// issued by the CAs in cas and stored in sink.
+func New(
+	k8sClient *kubernetes.Clientset,
+	sink generate.Sink,
+	cas map[string]*generate.CA,
+	specs []option.CertificateSpec,
+	requestTimeout time.Duration,
+) *Controller {
+	c := &Controller{
+		k8sClient:      k8sClient,
+		sink:           sink,
+		cas:            cas,
+		specs:          make(map[string]option.CertificateSpec, len(specs)),
+		requestTimeout: requestTimeout,
+		queue: workqueue.NewRateLimitingQueueWithConfig(
+			workqueue.DefaultControllerRateLimiter(),
+			workqueue.RateLimitingQueueConfig{Name: "certificates"},
+		),
+	}
+	for _, spec := range specs {
+		c.specs[secretKey(spec.SecretNamespace, spec.SecretName)] = spec
+	}
+	return c
+}
+
+// WithCAReload modifies to reload the CAs named names from their secret before
+// issuing certificates, and whenever their secret changes, so that the CAs
+// rotated or regenerated by other certgen runs are followed. The in-progress
+// rotation of the Cilium CA is also moved forward once its grace period has
+// elapsed, but no new rotation is started.
+func (c *Controller) WithCAReload(names []string) *Controller {
+	c.reload = make(map[string]struct{}, len(names))
+	for _, name := range names {
+		c.reload[name] = struct{}{}
+	}
+	return c
+}
+
+// WithLock modifies to acquire a lock with the lock function before each
+// reconciliation. lock returns a context canceled once the lock is lost, and
+// a function releasing it.
+func (c *Controller) WithLock(lock func() (context.Context, func(), error)) *Controller {
+	c.lock = lock
+	return c
+}
+
+// WithRecorder modifies to emit K8s Events with recorder on the secrets
+// written, and on the certificate secrets failing to be reconciled.
+func (c *Controller) WithRecorder(recorder *events.Recorder) *Controller {
+	c.recorder = recorder
+	return c
+}
+
+// secretKey returns the key identifying a secret in the work queue.
+func secretKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// Run starts watching the certificate secrets and reconciling them until ctx
+// is canceled.
+func (c *Controller) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		c.queue.ShutDown()
+		wg.Wait()
+	}()
+
+	synced := make([]cache.InformerSynced, 0, len(c.specs)+len(c.reload))
+	watch := func(namespace, name string, item any) error {
+		informer := cache.NewSharedInformer(
+			cache.NewListWatchFromClient(
+				c.k8sClient.CoreV1().RESTClient(), "secrets", namespace,
+				fields.OneTermEqualSelector("metadata.name", name),
+			),
+			&v1.Secret{}, 0,
+		)
+		_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(any) { c.queue.Add(item) },
+			UpdateFunc: func(any, any) { c.queue.Add(item) },
+			DeleteFunc: func(any) { c.queue.Add(item) },
+		})
+		if err != nil {
+			return fmt.Errorf("failed to register event handler for secret %s: %w", secretKey(namespace, name), err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			informer.Run(ctx.Done())
+		}()
+		synced = append(synced, informer.HasSynced)
+		return nil
+	}
+	for key, spec := range c.specs {
+		if err := watch(spec.SecretNamespace, spec.SecretName, key); err != nil {
+			return err
+		}
+	}
+	for name := range c.reload {
+		ca := c.cas[name]
+		if err := watch(ca.SecretNamespace, ca.SecretName, caKey(name)); err != nil {
+			return err
+		}
+	}
+
+	log.Info("Waiting for certificate secrets to be synced")
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		log.Info("Controller stopped before secrets were synced")
+		return nil
+	}
+
+	// Secrets which do not exist yet do not trigger any event.
+	for key := range c.specs {
+		c.queue.Add(key)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for c.processNextItem(ctx) {
+		}
+	}()
+
+	log.WithField(logfields.Count, len(c.specs)).Info("Controller started")
+	<-ctx.Done()
+	log.Info("Stopping controller")
+	return nil
+}
+
+// processNextItem reconciles the next secret or CA in the queue, and returns
+// false once the queue has been shut down.
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	item, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(item)
+
+	scopedLog := log.WithField(logfields.K8sSecret, item)
+	if name, ok := item.(caKey); ok {
+		scopedLog = log.WithField(logfields.CAName, name)
+	}
+
+	if c.lock != nil {
+		lockCtx, release, err := c.lock()
+		if err != nil {
+			scopedLog.WithError(err).Warn("Failed to acquire lock, retrying")
+			c.queue.AddRateLimited(item)
+			return true
+		}
+		defer release()
+
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		stop := context.AfterFunc(lockCtx, func() { cancel(context.Cause(lockCtx)) })
+		defer func() {
+			stop()
+			cancel(context.Canceled)
+		}()
+	}
+
+	var next time.Duration
+	var err error
+	switch key := item.(type) {
+	case caKey:
+		next, err = c.reconcileCA(ctx, string(key))
+	case string:
+		next, err = c.reconcile(ctx, key)
+	}
+	if err != nil {
+		scopedLog.WithError(err).Warn("Failed to reconcile, retrying")
+		// The failure is only reported once until the item is reconciled.
+		if key, ok := item.(string); ok && c.recorder != nil && c.queue.NumRequeues(item) == 0 {
+			spec := c.specs[key]
+			c.recorder.Secret(spec.SecretNamespace, spec.SecretName, v1.EventTypeWarning, events.ReasonIssuanceFailed,
+				"Failed to reconcile certificate: "+err.Error())
+		}
+		c.queue.AddRateLimited(item)
+		return true
+	}
+
+	c.queue.Forget(item)
+	// CAs are only reconciled again once their rotation is due.
+	if _, ok := item.(caKey); !ok || next > 0 {
+		c.queue.AddAfter(item, next)
+	}
+	return true
+}
+
+// reconcileCA reloads the CA name, and returns the time until its rotation
+// moves to the next phase, or zero if no rotation is in progress.
+func (c *Controller) reconcileCA(ctx context.Context, name string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout)
+	defer cancel()
+
+	ca, err := c.loadCA(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	next := ca.NextRotationPhaseAt(option.Config.CARotationGracePeriod)
+	if next.IsZero() {
+		return 0, nil
+	}
+	return max(time.Until(next), time.Second), nil
+}
+
+// loadCA returns the CA name, reloaded from its secret if it is reloadable.
+// The rotation of the Cilium CA is moved forward if due, and the certificates
+// issued by the CA are reconciled again if it changed.
+func (c *Controller) loadCA(ctx context.Context, name string) (*generate.CA, error) {
+	ca := c.cas[name]
+	if _, ok := c.reload[name]; !ok {
+		return ca, nil
+	}
+
+	// The CA is reloaded into a copy, so that it is left untouched if its
+	// secret cannot be loaded.
+	loaded := *ca
+	if err := loaded.LoadFromSecret(ctx, c.k8sClient); err != nil {
+		return nil, fmt.Errorf("failed to load CA %s from secret: %w", name, err)
+	}
+	if name == defaults.CAName {
+		rotated, err := loaded.AdvanceRotation(false, option.Config.CACommonName,
+			option.Config.CAValidityDuration, option.Config.CARotationGracePeriod)
+		if err != nil {
+			return nil, fmt.Errorf("failed to rotate CA %s: %w", name, err)
+		}
+		if rotated {
+			if err := loaded.Store(ctx, c.sink, true); err != nil {
+				return nil, fmt.Errorf("failed to store rotated CA %s: %w", name, err)
+			}
+			if c.recorder != nil {
+				c.recorder.CA(generate.NewCAReportEntry(name, &loaded, generate.ActionUpdated, loaded.RotationReason()))
+			}
+		}
+	}
+
+	if bytes.Equal(loaded.CACertBytes, ca.CACertBytes) && bytes.Equal(loaded.BundleBytes(), ca.BundleBytes()) &&
+		bytes.Equal(loaded.IssuerChainBytes(), ca.IssuerChainBytes()) {
+		return ca, nil
+	}
+	log.WithField(logfields.CAName, name).Info("CA changed, reconciling its certificates")
+	c.cas[name] = &loaded
+	for key, spec := range c.specs {
+		if spec.CA == name {
+			c.queue.Add(key)
+		}
+	}
+	return &loaded, nil
+}
+
+// reconcile creates or renews the certificate stored in the secret identified
+// by key if needed, and returns the time until it is due for renewal.
+func (c *Controller) reconcile(ctx context.Context, key string) (time.Duration, error) {
+	spec := c.specs[key]
+	scopedLog := log.WithField(logfields.CertName, spec.Name)
+
+	renewBefore, err := generate.ParseRenewBefore(spec.RenewBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout)
+	defer cancel()
+
+	// The CA is reloaded first, so that a certificate issued by a CA rotated
+	// or regenerated by another run is not renewed with the previous CA.
+	ca, err := c.loadCA(ctx, spec.CA)
+	if err != nil {
+		return 0, err
+	}
+
+	cert := spec.NewCert()
+	action, err := cert.CheckRenewal(ctx, c.k8sClient, ca, renewBefore)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check renewal: %w", err)
+	}
+
+	if action == generate.ActionCreated || action == generate.ActionRenewed {
+		scopedLog.Info("Generating certificate")
+		if err := cert.Generate(ca); err != nil {
+			return 0, fmt.Errorf("failed to generate certificate: %w", err)
+		}
+	}
+	if action != generate.ActionKept {
+		if err := cert.Store(ctx, c.sink); err != nil {
+			return 0, fmt.Errorf("failed to store certificate: %w", err)
+		}
+		if c.recorder != nil {
+			c.recorder.Certificate(generate.NewCertReportEntry(spec.Name, cert, action))
+		}
+	}
+
+	x509Cert, err := cert.Certificate()
+	if err != nil {
+		return 0, err
+	}
+	renewAt := renewBefore.RenewalTime(x509Cert)
+	scopedLog.WithFields(logrus.Fields{
+		logfields.Action:  action,
+		logfields.RenewAt: renewAt,
+	}).Info("Certificate reconciled")
+	return time.Until(renewAt), nil
+}
@@ -0,0 +1,253 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/cilium/certgen/internal/generate"
+	"github.com/cilium/certgen/internal/logging/logfields"
+)
+
+const (
+	// reasonPolicyViolation is the reason of the conditions set on the
+	// CertificateSigningRequests violating the signing policy.
+	reasonPolicyViolation = "CertgenPolicyViolation"
+	// reasonSigningFailed is the reason of the failed condition set on the
+	// CertificateSigningRequests which could not be signed.
+	reasonSigningFailed = "CertgenSigningFailed"
+)
+
+// Signer signs the K8s CertificateSigningRequests requesting its signer name
+// with a CA, enforcing a signing policy. Pending requests violating the policy
+// are denied, and approved ones are marked as failed. Approving the requests
+// which comply with the policy is left to the cluster administrators or
+// another approver.
+type Signer struct {
+	k8sClient      *kubernetes.Clientset
+	signerName     string
+	ca             *generate.CA
+	policy         generate.SigningPolicy
+	requestTimeout time.Duration
+
+	informer cache.SharedInformer
+	queue    workqueue.RateLimitingInterface
+}
+
+// NewSigner creates a new signer for the CertificateSigningRequests requesting
+// signerName. The requests are signed by ca, which is loaded from its secret
+// before each signature so that CA rotations are taken into account.
+func NewSigner(
+	k8sClient *kubernetes.Clientset,
+	signerName string,
+	ca *generate.CA,
+	policy generate.SigningPolicy,
+	requestTimeout time.Duration,
+) *Signer {
+	return &Signer{
+		k8sClient:      k8sClient,
+		signerName:     signerName,
+		ca:             ca,
+		policy:         policy,
+		requestTimeout: requestTimeout,
+		informer: cache.NewSharedInformer(
+			cache.NewListWatchFromClient(
+				k8sClient.CertificatesV1().RESTClient(), "certificatesigningrequests", meta_v1.NamespaceAll,
+				fields.OneTermEqualSelector("spec.signerName", signerName),
+			),
+			&certificatesv1.CertificateSigningRequest{}, 0,
+		),
+		queue: workqueue.NewRateLimitingQueueWithConfig(
+			workqueue.DefaultControllerRateLimiter(),
+			workqueue.RateLimitingQueueConfig{Name: "certificatesigningrequests"},
+		),
+	}
+}
+
+// Run starts watching the CertificateSigningRequests and signing them until
+// ctx is canceled.
+func (s *Signer) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		s.queue.ShutDown()
+		wg.Wait()
+	}()
+
+	enqueue := func(obj any) {
+		if key, err := cache.MetaNamespaceKeyFunc(obj); err == nil {
+			s.queue.Add(key)
+		}
+	}
+	_, err := s.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, obj any) { enqueue(obj) },
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register event handler for CertificateSigningRequests: %w", err)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.informer.Run(ctx.Done())
+	}()
+
+	log.WithField(logfields.K8sSignerName, s.signerName).Info("Waiting for CertificateSigningRequests to be synced")
+	if !cache.WaitForCacheSync(ctx.Done(), s.informer.HasSynced) {
+		log.Info("Signer stopped before CertificateSigningRequests were synced")
+		return nil
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for s.processNextItem(ctx) {
+		}
+	}()
+
+	log.WithField(logfields.K8sSignerName, s.signerName).Info("Signer started")
+	<-ctx.Done()
+	log.Info("Stopping signer")
+	return nil
+}
+
+// processNextItem reconciles the next CertificateSigningRequest in the queue,
+// and returns false once the queue has been shut down.
+func (s *Signer) processNextItem(ctx context.Context) bool {
+	item, shutdown := s.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer s.queue.Done(item)
+
+	name := item.(string)
+	if err := s.reconcile(ctx, name); err != nil {
+		log.WithError(err).WithField(logfields.K8sCSRName, name).Warn("Failed to reconcile CertificateSigningRequest, retrying")
+		s.queue.AddRateLimited(name)
+		return true
+	}
+	s.queue.Forget(name)
+	return true
+}
+
+// reconcile denies, fails or signs the CertificateSigningRequest name,
+// depending on its approval and the signing policy.
+func (s *Signer) reconcile(ctx context.Context, name string) error {
+	obj, exists, err := s.informer.GetStore().GetByKey(name)
+	if err != nil || !exists {
+		return err
+	}
+	csr := obj.(*certificatesv1.CertificateSigningRequest)
+	if csr.Spec.SignerName != s.signerName || len(csr.Status.Certificate) != 0 {
+		return nil
+	}
+	approved := false
+	for _, cond := range csr.Status.Conditions {
+		switch cond.Type {
+		case certificatesv1.CertificateDenied, certificatesv1.CertificateFailed:
+			return nil
+		case certificatesv1.CertificateApproved:
+			approved = true
+		}
+	}
+	scopedLog := log.WithFields(logrus.Fields{
+		logfields.K8sCSRName:    csr.Name,
+		logfields.K8sSignerName: s.signerName,
+	})
+
+	ctx, cancel := context.WithTimeout(ctx, s.requestTimeout)
+	defer cancel()
+
+	usages := make([]string, 0, len(csr.Spec.Usages))
+	for _, usage := range csr.Spec.Usages {
+		usages = append(usages, string(usage))
+	}
+	req, err := generate.ParseCSR(csr.Spec.Request)
+	if err == nil {
+		err = s.policy.Check(req, usages)
+	}
+	if err != nil {
+		scopedLog.WithError(err).Info("CertificateSigningRequest violates the signing policy")
+		if approved {
+			// Approved requests cannot be denied anymore.
+			return s.updateStatus(ctx, csr, certificatesv1.CertificateFailed, reasonPolicyViolation, err.Error())
+		}
+		return s.deny(ctx, csr, err.Error())
+	}
+	if !approved {
+		scopedLog.Debug("CertificateSigningRequest waiting for approval")
+		return nil
+	}
+
+	if err := s.ca.LoadFromSecret(ctx, s.k8sClient); err != nil {
+		return fmt.Errorf("failed to load CA from secret: %w", err)
+	}
+	var requested time.Duration
+	if csr.Spec.ExpirationSeconds != nil {
+		requested = time.Duration(*csr.Spec.ExpirationSeconds) * time.Second
+	}
+	duration := s.policy.Duration(requested)
+	certBytes, err := s.ca.SignCSR(csr.Spec.Request, usages, duration)
+	if err != nil {
+		scopedLog.WithError(err).Warn("Failed to sign CertificateSigningRequest")
+		return s.updateStatus(ctx, csr, certificatesv1.CertificateFailed, reasonSigningFailed, err.Error())
+	}
+
+	csr = csr.DeepCopy()
+	csr.Status.Certificate = certBytes
+	if _, err := s.k8sClient.CertificatesV1().CertificateSigningRequests().UpdateStatus(ctx, csr, meta_v1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update status: %w", err)
+	}
+	scopedLog.WithField(logfields.CertValidityDuration, duration).Info("Signed CertificateSigningRequest")
+	return nil
+}
+
+// deny sets the denied condition on csr through its approval subresource.
+func (s *Signer) deny(ctx context.Context, csr *certificatesv1.CertificateSigningRequest, message string) error {
+	csr = csr.DeepCopy()
+	csr.Status.Conditions = append(csr.Status.Conditions, csrCondition(certificatesv1.CertificateDenied, reasonPolicyViolation, message))
+	if _, err := s.k8sClient.CertificatesV1().CertificateSigningRequests().UpdateApproval(ctx, csr.Name, csr, meta_v1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to deny: %w", err)
+	}
+	return nil
+}
+
+// updateStatus sets a condition on csr through its status subresource.
+func (s *Signer) updateStatus(ctx context.Context, csr *certificatesv1.CertificateSigningRequest,
+	condType certificatesv1.RequestConditionType, reason, message string,
+) error {
+	csr = csr.DeepCopy()
+	csr.Status.Conditions = append(csr.Status.Conditions, csrCondition(condType, reason, message))
+	if _, err := s.k8sClient.CertificatesV1().CertificateSigningRequests().UpdateStatus(ctx, csr, meta_v1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update status: %w", err)
+	}
+	return nil
+}
+
+// csrCondition returns a true CertificateSigningRequest condition.
+func csrCondition(condType certificatesv1.RequestConditionType, reason, message string) certificatesv1.CertificateSigningRequestCondition {
+	now := meta_v1.Now()
+	return certificatesv1.CertificateSigningRequestCondition{
+		Type:               condType,
+		Status:             v1.ConditionTrue,
+		Reason:             reason,
+		Message:            message,
+		LastUpdateTime:     now,
+		LastTransitionTime: now,
+	}
+}
@@ -9,12 +9,81 @@ const (
 	// Debug enables debug messages.
 	Debug = false
 
+	// DryRun can be set to true to only print the planned changes.
+	DryRun = false
+	// DryRunOutput is the format of the plan printed in dry-run mode.
+	DryRunOutput = "text"
+
+	// InspectOutput is the format of the report printed by the inspect
+	// command.
+	InspectOutput = "text"
+	// InspectWarnBefore is the window before expiry in which the inspect
+	// command reports certificates as expiring.
+	InspectWarnBefore = "720h"
+
 	// CiliumNamespace is the Kubernetes namespace in which Cilium is
 	// installed.
 	CiliumNamespace = "kube-system"
 
 	// K8sRequestTimeout specifies the timeout for K8s API requests.
 	K8sRequestTimeout = 60 * time.Second
+	// K8sClientQPS is the maximum sustained rate of K8s API requests per
+	// second.
+	K8sClientQPS = 5
+	// K8sClientBurst is the maximum burst of K8s API requests.
+	K8sClientBurst = 10
+
+	// Workers is the number of certificates generated and stored
+	// concurrently, 0 for the number of CPUs.
+	Workers = 0
+
+	// ForceConflicts can be set to true to take ownership of the secret
+	// fields managed by other field managers.
+	ForceConflicts = false
+
+	// Events can be set to true to emit K8s Events on the CA and
+	// certificate secrets, and on the certgen Job or Pod.
+	Events = false
+
+	// LeaseLock can be set to true to acquire a K8s Lease before loading the
+	// CAs and writing the secrets.
+	LeaseLock = false
+	// LeaseLockName is the name of the K8s Lease used as lock.
+	LeaseLockName = "cilium-certgen"
+	// LeaseLockTimeout is the maximum time to wait for the K8s Lease.
+	LeaseLockTimeout = 5 * time.Minute
+
+	// CAStoreFile can be set to true to store the Cilium CA loaded from
+	// the provided files in the CA secret.
+	CAStoreFile = false
+	// CAKeyPassphraseSecretKey is the key of the K8s Secret holding the
+	// passphrase of the encrypted Cilium CA key.
+	CAKeyPassphraseSecretKey = "passphrase"
+	// CAKeyEncrypt can be set to true to encrypt the Cilium CA key stored in
+	// the CA secret.
+	CAKeyEncrypt = false
+
+	// RemoteSignerTimeout is the timeout of the requests to the remote cfssl
+	// server.
+	RemoteSignerTimeout = 30 * time.Second
+
+	// CSRAutoApprove can be set to true to approve the K8s
+	// CertificateSigningRequests created by certgen.
+	CSRAutoApprove = false
+	// CSRTimeout is the maximum time to wait for a K8s
+	// CertificateSigningRequest to be issued.
+	CSRTimeout = 5 * time.Minute
+	// CSRCABundleKey is the key of the K8s ConfigMap or Secret holding the
+	// CA bundle of the CSR signer.
+	CSRCABundleKey = "ca.crt"
+
+	// CASignerMaxDuration is the maximum validity of the certificates signed
+	// by the Cilium CA signer.
+	CASignerMaxDuration = 365 * 24 * time.Hour
+
+	// CertManagerIssuerName is the name of the cert-manager Issuer backed by
+	// the Cilium CA secret.
+	CertManagerIssuerName = "cilium-ca"
 
 	// CAGenerate can be set to true to generate a new Cilium CA secret.
 	// If CAReuseSecret is true, then a new CA secret only is created if
@@ -29,9 +98,49 @@ const (
 	// CAValidityDuration represent how much time the Cilium CA certificate
 	// generated by certgen is valid.
 	CAValidityDuration = 3 * 365 * 24 * time.Hour
+	// CAKeyAlgorithm is the algorithm of the Cilium CA private key.
+	CAKeyAlgorithm = "ecdsa"
+	// CAKeySize is the size of the Cilium CA private key, zero selecting the
+	// default size of the algorithm.
+	CAKeySize = 0
+	// CACountry is the Cilium CA x509 certificate country (C) value.
+	CACountry = "US"
+	// CAState is the Cilium CA x509 certificate state or province (ST) value.
+	CAState = "San Francisco"
+	// CALocality is the Cilium CA x509 certificate locality (L) value.
+	CALocality = "CA"
+	// CAOrganization is the Cilium CA x509 certificate organization (O) value.
+	CAOrganization = "Cilium"
+	// CAOrganizationalUnit is the Cilium CA x509 certificate organizational unit (OU) value.
+	CAOrganizationalUnit = "Cilium"
+	// CASerialNumber is the Cilium CA x509 certificate subject serial number value.
+	CASerialNumber = ""
 	// CASecretName is the Kubernetes Secret in which the Cilium CA certificate
 	// is read from and/or written to.
 	CASecretName = "cilium-ca"
+	// CARotate can be set to true to start a rotation of the Cilium CA.
+	CARotate = false
+	// CARotationGracePeriod is the minimum time between two phases of a
+	// Cilium CA rotation.
+	CARotationGracePeriod = 24 * time.Hour
+	// CAName is the name referring to the Cilium CA in certificate specs.
+	CAName = "cilium"
+
+	// CertReuseSecret can be set to true to keep the existing certificate
+	// secrets unless they are due for renewal.
+	CertReuseSecret = false
+	// CertRenewBefore is the window before expiry in which certificates are
+	// renewed, as duration or as percentage of the certificate lifetime.
+	CertRenewBefore = "33%"
+	// CertKeyAlgorithm is the algorithm of the certificate private keys.
+	CertKeyAlgorithm = "ecdsa"
+	// CertKeySize is the size of the certificate private keys, zero
+	// selecting the default size of the algorithm.
+	CertKeySize = 0
+
+	// CertValidityDuration represent how much time the certificates listed in
+	// the spec file are valid, unless specified otherwise.
+	CertValidityDuration = 3 * 365 * 24 * time.Hour
 
 	// HubbleServerCertGenerate can be set to true to generate and store a
 	// Hubble server TLS certificate.
@@ -140,6 +249,17 @@ const (
 )
 
 var (
+	// OutputSinks are the default sinks storing the generated CAs and
+	// certificates.
+	OutputSinks = []string{"secret"}
+
+	// CASignerAllowedUsages are the key usages which may be requested from
+	// the Cilium CA signer.
+	CASignerAllowedUsages = []string{"signing", "digital signature", "key encipherment", "server auth", "client auth"}
+	// CASignerAllowedDNSNames are the DNS names which may be requested from
+	// the Cilium CA signer.
+	CASignerAllowedDNSNames = []string{"*.cilium.io"}
+
 	// HubbleServerCertUsage are the key usages for the Hubble server x509
 	// certificate.
 	HubbleServerCertUsage = []string{"signing", "key encipherment", "server auth"}
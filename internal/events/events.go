@@ -0,0 +1,230 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package events
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/cilium/certgen/internal/generate"
+	"github.com/cilium/certgen/internal/logging"
+	"github.com/cilium/certgen/internal/logging/logfields"
+)
+
+var (
+	log = logging.DefaultLogger.WithField(logfields.LogSubsys, "events")
+)
+
+const (
+	// ReasonCertificateIssued is the reason of the events emitted when a
+	// CA or certificate secret is created.
+	ReasonCertificateIssued = "CertificateIssued"
+	// ReasonCertificateRenewed is the reason of the events emitted when a
+	// certificate is renewed, or a CA rotated.
+	ReasonCertificateRenewed = "CertificateRenewed"
+	// ReasonSecretUpdated is the reason of the events emitted when a
+	// certificate is kept, but the CA bundle or chain of its secret updated.
+	ReasonSecretUpdated = "SecretUpdated"
+	// ReasonCAReused is the reason of the events emitted when an existing CA
+	// secret is reused.
+	ReasonCAReused = "CAReused"
+	// ReasonIssuanceFailed is the reason of the events emitted when a run
+	// fails.
+	ReasonIssuanceFailed = "IssuanceFailed"
+	// ReasonIssuanceSucceeded is the reason of the event emitted on the
+	// certgen Job or Pod when a run succeeds.
+	ReasonIssuanceSucceeded = "IssuanceSucceeded"
+)
+
+// serviceAccountNamespaceFile is the file holding the namespace of the pod,
+// when running in K8s.
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// Recorder emits K8s Events on the secrets managed by certgen, and on the Job
+// (or Pod) certgen runs in, if any.
+type Recorder struct {
+	k8sClient      *kubernetes.Clientset
+	requestTimeout time.Duration
+
+	broadcaster record.EventBroadcaster
+	recorder    record.EventRecorder
+	// pending tracks the events which have not been written yet.
+	pending sync.WaitGroup
+
+	// run is the Job or Pod certgen runs in, or nil if unknown.
+	run runtime.Object
+}
+
+// NewRecorder creates a new recorder emitting events as component. If
+// inCluster is true, the Job or Pod certgen runs in is looked up to emit the
+// run events on it. requestTimeout bounds each request made by the recorder.
+func NewRecorder(k8sClient *kubernetes.Clientset, component string, inCluster bool, requestTimeout time.Duration) *Recorder {
+	r := &Recorder{
+		k8sClient:      k8sClient,
+		requestTimeout: requestTimeout,
+		broadcaster:    record.NewBroadcaster(),
+	}
+	r.broadcaster.StartEventWatcher(r.write)
+	hostname, _ := os.Hostname()
+	r.recorder = r.broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: component, Host: hostname})
+	if inCluster {
+		r.run = r.lookupRun(hostname)
+	}
+	return r
+}
+
+// lookupRun returns the Job owning the pod certgen runs in, the pod if it is
+// not owned by a Job, or nil if the pod cannot be read. The pod name is the
+// hostname.
+func (r *Recorder) lookupRun(podName string) runtime.Object {
+	namespace, err := os.ReadFile(serviceAccountNamespaceFile)
+	if err != nil {
+		log.WithError(err).Debug("Failed to read pod namespace, not emitting events on the certgen pod")
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.requestTimeout)
+	defer cancel()
+
+	pod, err := r.k8sClient.CoreV1().Pods(strings.TrimSpace(string(namespace))).Get(ctx, podName, meta_v1.GetOptions{})
+	if err != nil {
+		log.WithError(err).Debug("Failed to get certgen pod, not emitting events on it")
+		return nil
+	}
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind != "Job" {
+			continue
+		}
+		job, err := r.k8sClient.BatchV1().Jobs(pod.Namespace).Get(ctx, owner.Name, meta_v1.GetOptions{})
+		if err != nil {
+			log.WithError(err).Debug("Failed to get certgen job, emitting events on the pod")
+			break
+		}
+		return job
+	}
+	return pod
+}
+
+// Secret emits an event on the secret namespace/name, if it exists.
+func (r *Recorder) Secret(namespace, name, eventtype, reason, message string) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.requestTimeout)
+	defer cancel()
+
+	// The secret is read so that the event refers to its UID, which is
+	// used to list the events of an object (e.g. by kubectl describe).
+	secret, err := r.k8sClient.CoreV1().Secrets(namespace).Get(ctx, name, meta_v1.GetOptions{})
+	if err != nil {
+		log.WithError(err).WithFields(logrus.Fields{
+			logfields.K8sSecretNamespace: namespace,
+			logfields.K8sSecretName:      name,
+		}).Debug("Failed to get K8s Secret, not emitting event on it")
+		return
+	}
+	r.event(secret, eventtype, reason, message)
+}
+
+// Run emits an event on the Job or Pod certgen runs in, if known.
+func (r *Recorder) Run(eventtype, reason, message string) {
+	if r.run == nil {
+		return
+	}
+	r.event(r.run, eventtype, reason, message)
+}
+
+// event emits an event on object.
+func (r *Recorder) event(object runtime.Object, eventtype, reason, message string) {
+	r.pending.Add(1)
+	r.recorder.Event(object, eventtype, reason, message)
+}
+
+// Shutdown waits up to timeout for the emitted events to be written, and
+// shuts the recorder down.
+func (r *Recorder) Shutdown(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		r.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Warn("Timed out writing K8s Events, some events may be lost")
+	}
+	r.broadcaster.Shutdown()
+}
+
+// write writes event, and marks it as written in pending whether it succeeds
+// or not, so that Shutdown does not wait for the events which cannot be
+// written. As certgen emits few events, they are written once, without
+// retries nor aggregation.
+func (r *Recorder) write(event *v1.Event) {
+	defer r.pending.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.requestTimeout)
+	defer cancel()
+
+	_, err := r.k8sClient.CoreV1().Events(event.Namespace).Create(ctx, event, meta_v1.CreateOptions{})
+	if err != nil {
+		log.WithError(err).WithFields(logrus.Fields{
+			logfields.K8sKind:   event.InvolvedObject.Kind,
+			logfields.K8sObject: event.InvolvedObject.Namespace + "/" + event.InvolvedObject.Name,
+		}).Warn("Failed to write K8s Event")
+	}
+}
+
+// CA emits the event describing the action taken for the CA of the report
+// entry e, if any.
+func (r *Recorder) CA(e generate.ReportEntry) {
+	switch {
+	case e.Action == generate.ActionCreated:
+		r.Secret(e.SecretNamespace, e.SecretName, v1.EventTypeNormal, ReasonCertificateIssued,
+			fmt.Sprintf("Issued CA certificate %s", describeEntry(e)))
+	case e.Action == generate.ActionUpdated:
+		r.Secret(e.SecretNamespace, e.SecretName, v1.EventTypeNormal, ReasonCertificateRenewed,
+			fmt.Sprintf("%s: CA certificate %s", e.Reason, describeEntry(e)))
+	case e.Action == generate.ActionKept && e.Reason == generate.ReasonLoadedFromSecret:
+		r.Secret(e.SecretNamespace, e.SecretName, v1.EventTypeNormal, ReasonCAReused,
+			fmt.Sprintf("Reused existing CA certificate %s", describeEntry(e)))
+	}
+}
+
+// Certificate emits the event describing the action taken for the
+// certificate of the report entry e, if any.
+func (r *Recorder) Certificate(e generate.ReportEntry) {
+	switch e.Action {
+	case generate.ActionCreated:
+		r.Secret(e.SecretNamespace, e.SecretName, v1.EventTypeNormal, ReasonCertificateIssued,
+			fmt.Sprintf("Issued certificate %s", describeEntry(e)))
+	case generate.ActionRenewed:
+		r.Secret(e.SecretNamespace, e.SecretName, v1.EventTypeNormal, ReasonCertificateRenewed,
+			fmt.Sprintf("Renewed certificate %s", describeEntry(e)))
+	case generate.ActionUpdated:
+		r.Secret(e.SecretNamespace, e.SecretName, v1.EventTypeNormal, ReasonSecretUpdated,
+			fmt.Sprintf("Updated CA bundle or chain of certificate %s", describeEntry(e)))
+	}
+}
+
+// describeEntry describes the certificate of a report entry.
+func describeEntry(e generate.ReportEntry) string {
+	s := fmt.Sprintf("%q", e.CommonName)
+	if e.SerialNumber != "" {
+		s += " (serial " + e.SerialNumber + ")"
+	}
+	if e.NotAfter != nil {
+		s += ", valid until " + e.NotAfter.Format(time.RFC3339)
+	}
+	return s
+}
@@ -0,0 +1,185 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package generate
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net"
+	"net/mail"
+	"net/url"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// certManagerAPIVersion is the API version of the cert-manager resources.
+	certManagerAPIVersion = "cert-manager.io/v1"
+)
+
+var (
+	// IssuerResource is the cert-manager Issuer resource.
+	IssuerResource = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "issuers"}
+	// CertificateResource is the cert-manager Certificate resource.
+	CertificateResource = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+)
+
+// WithTLSKeys modifies to also store the CA certificate and key in the tls.crt
+// and tls.key keys of the CA secret, so that the secret can back a
+// cert-manager CA issuer.
+func (c *CA) WithTLSKeys(tlsKeys bool) *CA {
+	c.tlsKeys = tlsKeys
+	return c
+}
+
+// CertManagerIssuer returns the cert-manager Issuer named name which issues
+// certificates with the key stored in the secret of c. The CA secret must
+// hold the tls.crt and tls.key keys, see WithTLSKeys.
+func (c *CA) CertManagerIssuer(name string) (*unstructured.Unstructured, error) {
+	if c.SecretName == "" {
+		return nil, errors.New("CA is not stored in a secret")
+	}
+	issuer := &unstructured.Unstructured{Object: map[string]any{
+		"spec": map[string]any{
+			"ca": map[string]any{
+				"secretName": c.SecretName,
+			},
+		},
+	}}
+	issuer.SetAPIVersion(certManagerAPIVersion)
+	issuer.SetKind("Issuer")
+	issuer.SetName(name)
+	issuer.SetNamespace(c.SecretNamespace)
+	issuer.SetLabels(map[string]string{LabelManagedBy: ManagedBy})
+	return issuer, nil
+}
+
+// CertManagerCertificate returns the cert-manager Certificate issued by the
+// Issuer issuerName, which describes the same certificate and secret as c.
+// The Certificate is named after the secret of c, and renewed by cert-manager
+// within the renewBefore window.
+func (c *Cert) CertManagerCertificate(issuerName string, renewBefore RenewBefore) (*unstructured.Unstructured, error) {
+	if c.ValidityDuration <= 0 {
+		return nil, fmt.Errorf("invalid validity duration %s", c.ValidityDuration)
+	}
+	spec := map[string]any{
+		"secretName": c.Name,
+		"commonName": c.CommonName,
+		"duration":   c.ValidityDuration.String(),
+		"usages":     toAnySlice(c.Usage),
+		"issuerRef": map[string]any{
+			"group": "cert-manager.io",
+			"kind":  "Issuer",
+			"name":  issuerName,
+		},
+	}
+
+	// The hosts are split the same way as cfssl does when signing.
+	var dnsNames, ipAddresses, emailAddresses, uris []string
+	for _, host := range c.Hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			ipAddresses = append(ipAddresses, host)
+		} else if email, err := mail.ParseAddress(host); err == nil && email != nil {
+			emailAddresses = append(emailAddresses, email.Address)
+		} else if uri, err := url.ParseRequestURI(host); err == nil && uri != nil {
+			uris = append(uris, host)
+		} else {
+			dnsNames = append(dnsNames, host)
+		}
+	}
+	for key, values := range map[string][]string{
+		"dnsNames":       dnsNames,
+		"ipAddresses":    ipAddresses,
+		"emailAddresses": emailAddresses,
+		"uris":           uris,
+	} {
+		if len(values) != 0 {
+			spec[key] = toAnySlice(values)
+		}
+	}
+
+	switch {
+	case renewBefore.Percentage != 0:
+		spec["renewBeforePercentage"] = int64(math.Round(renewBefore.Percentage))
+	case renewBefore.Duration != 0:
+		spec["renewBefore"] = renewBefore.Duration.String()
+	}
+
+	// A new key is generated on each issuance, as certgen does.
+	privateKey := map[string]any{"rotationPolicy": "Always"}
+	kr := newKeyRequest(c.KeyAlgorithm, c.KeySize)
+	switch kr.A {
+	case KeyAlgorithmECDSA:
+		privateKey["algorithm"] = "ECDSA"
+		privateKey["size"] = int64(kr.S)
+	case KeyAlgorithmRSA:
+		privateKey["algorithm"] = "RSA"
+		privateKey["size"] = int64(kr.S)
+	case KeyAlgorithmEd25519:
+		privateKey["algorithm"] = "Ed25519"
+	}
+	spec["privateKey"] = privateKey
+
+	subject := map[string]any{}
+	for key, value := range map[string]string{
+		"countries":           c.Subject.Country,
+		"provinces":           c.Subject.State,
+		"localities":          c.Subject.Locality,
+		"organizations":       c.Subject.Organization,
+		"organizationalUnits": c.Subject.OrganizationalUnit,
+	} {
+		if value != "" {
+			subject[key] = []any{value}
+		}
+	}
+	if c.Subject.SerialNumber != "" {
+		subject["serialNumber"] = c.Subject.SerialNumber
+	}
+	if len(subject) != 0 {
+		spec["subject"] = subject
+	}
+
+	// The secret gets the user-provided labels and annotations, but not the
+	// ones describing the certificate, which cert-manager sets itself.
+	labels := map[string]string{LabelManagedBy: ManagedBy}
+	if c.Metadata.Component != "" {
+		labels[LabelComponent] = c.Metadata.Component
+	}
+	secretLabels := maps.Clone(labels)
+	maps.Copy(secretLabels, c.Metadata.Labels)
+	secretTemplate := map[string]any{"labels": toAnyMap(secretLabels)}
+	if len(c.Metadata.Annotations) != 0 {
+		secretTemplate["annotations"] = toAnyMap(c.Metadata.Annotations)
+	}
+	spec["secretTemplate"] = secretTemplate
+
+	cert := &unstructured.Unstructured{Object: map[string]any{"spec": spec}}
+	cert.SetAPIVersion(certManagerAPIVersion)
+	cert.SetKind("Certificate")
+	cert.SetName(c.Name)
+	cert.SetNamespace(c.Namespace)
+	cert.SetLabels(labels)
+	return cert, nil
+}
+
+// toAnySlice converts values for use in unstructured objects.
+func toAnySlice(values []string) []any {
+	out := make([]any, 0, len(values))
+	for _, v := range values {
+		out = append(out, v)
+	}
+	return out
+}
+
+// toAnyMap converts values for use in unstructured objects.
+func toAnyMap(values map[string]string) map[string]any {
+	out := make(map[string]any, len(values))
+	for k, v := range values {
+		out[k] = v
+	}
+	return out
+}
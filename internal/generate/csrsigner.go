@@ -0,0 +1,253 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package generate
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/sirupsen/logrus"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	v1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+
+	"github.com/cilium/certgen/internal/logging/logfields"
+)
+
+const (
+	// AnnotationSecret is the annotation of the CertificateSigningRequests
+	// created by certgen storing the namespace/name of the secret the
+	// certificate is stored in.
+	AnnotationSecret = annotationPrefix + "secret"
+
+	// csrApprovalReason is the reason of the approval condition set on the
+	// CertificateSigningRequests auto-approved by certgen.
+	csrApprovalReason = "CertgenAutoApproved"
+	// csrPollInterval is the interval at which the status of the
+	// CertificateSigningRequests is checked.
+	csrPollInterval = time.Second
+	// minCSRExpirationSeconds is the minimum duration which can be requested
+	// for a certificate signed through a CertificateSigningRequest.
+	minCSRExpirationSeconds = 600
+)
+
+// CSRSigner issues certificates through K8s CertificateSigningRequests, which
+// are signed by the signer they request.
+type CSRSigner struct {
+	k8sClient      *kubernetes.Clientset
+	signerName     string
+	autoApprove    bool
+	timeout        time.Duration
+	requestTimeout time.Duration
+}
+
+// NewCSRSigner creates a signer requesting certificates from signerName. If
+// autoApprove is true, the CertificateSigningRequests are approved by certgen
+// itself, which requires the RBAC permission to approve for signerName.
+// timeout bounds the time waiting for a certificate to be issued, and
+// requestTimeout each K8s API request.
+func NewCSRSigner(k8sClient *kubernetes.Clientset, signerName string, autoApprove bool, timeout, requestTimeout time.Duration) *CSRSigner {
+	return &CSRSigner{
+		k8sClient:      k8sClient,
+		signerName:     signerName,
+		autoApprove:    autoApprove,
+		timeout:        timeout,
+		requestTimeout: requestTimeout,
+	}
+}
+
+// SignerName returns the signer the certificates are requested from.
+func (s *CSRSigner) SignerName() string {
+	return s.signerName
+}
+
+// LoadFromCSRSigner populates c.CACertBytes with bundleBytes, the PEM bundle
+// of the CA certificates of signer s, which then issues the certificates of
+// this CA instead of a local key.
+func (c *CA) LoadFromCSRSigner(s *CSRSigner, bundleBytes []byte) error {
+	bundle, err := helpers.ParseCertificatesPEM(bundleBytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse CA bundle: %w", err)
+	}
+	if len(bundle) == 0 {
+		return errors.New("CA bundle contains no certificate")
+	}
+
+	// The CA issuing the certificates is unknown, so CACert is not set and
+	// the certificates are checked against the whole bundle instead.
+	c.CACertBytes = bundleBytes
+	c.CAKeyBytes = nil
+	c.CACert = nil
+	c.CAKey = nil
+	c.ChainBytes = nil
+	c.RootCertBytes = nil
+	c.rotation = caRotation{}
+	c.loadedFromSecret = false
+	c.external = s
+	return nil
+}
+
+// bundleCerts returns the certificates of the CA bundle of a CA loaded with
+// LoadFromCSRSigner, or nil for any other CA or a nil CA.
+func (c *CA) bundleCerts() []*x509.Certificate {
+	if c == nil || c.CACert != nil || len(c.CACertBytes) == 0 {
+		return nil
+	}
+	certs, _ := helpers.ParseCertificatesPEM(c.CACertBytes)
+	return certs
+}
+
+// sign implements externalSigner, creating a CertificateSigningRequest for c,
+// approving it if enabled, and waiting for the certificate to be issued. The
+// CertificateSigningRequest is deleted once done, whether the certificate was
+// issued or not. Only the leaf certificate is returned, any intermediate CA
+// certificate needs to be part of the CA bundle.
+func (s *CSRSigner) sign(ctx context.Context, c *Cert, ca *CA, csrPEM []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	csr, err := s.create(ctx, c, csrPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CertificateSigningRequest: %w", err)
+	}
+	scopedLog := logger(ctx).WithFields(logrus.Fields{
+		logfields.K8sCSRName:    csr.Name,
+		logfields.K8sSignerName: s.signerName,
+	})
+	scopedLog.Info("Created CertificateSigningRequest")
+	defer s.delete(scopedLog, csr.Name)
+
+	if s.autoApprove {
+		if err := s.approve(ctx, csr); err != nil {
+			return nil, fmt.Errorf("failed to approve CertificateSigningRequest %s: %w", csr.Name, err)
+		}
+		scopedLog.Info("Approved CertificateSigningRequest")
+	}
+
+	certBytes, err := s.wait(ctx, csr.Name)
+	if err != nil {
+		return nil, err
+	}
+	scopedLog.Info("CertificateSigningRequest issued")
+
+	chain, err := helpers.ParseCertificatesPEM(certBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate issued for CertificateSigningRequest %s: %w", csr.Name, err)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("CertificateSigningRequest %s has no certificate", csr.Name)
+	}
+	if err := verifyChain(chain[0], ca.bundleCerts(), chain[1:]); err != nil {
+		return nil, fmt.Errorf("certificate issued for CertificateSigningRequest %s does not chain to the CA bundle: %w", csr.Name, err)
+	}
+	return leafCertBytes(certBytes), nil
+}
+
+// create creates the CertificateSigningRequest of c.
+func (s *CSRSigner) create(ctx context.Context, c *Cert, csrPEM []byte) (*certificatesv1.CertificateSigningRequest, error) {
+	usages := make([]certificatesv1.KeyUsage, 0, len(c.Usage))
+	for _, usage := range c.Usage {
+		usages = append(usages, certificatesv1.KeyUsage(usage))
+	}
+	expirationSeconds := int32(min(max(c.ValidityDuration.Seconds(), minCSRExpirationSeconds), math.MaxInt32))
+
+	csr := &certificatesv1.CertificateSigningRequest{
+		ObjectMeta: meta_v1.ObjectMeta{
+			GenerateName: c.Name + "-",
+			Labels:       map[string]string{LabelManagedBy: ManagedBy},
+			Annotations:  map[string]string{AnnotationSecret: c.Namespace + "/" + c.Name},
+		},
+		Spec: certificatesv1.CertificateSigningRequestSpec{
+			Request:           csrPEM,
+			SignerName:        s.signerName,
+			Usages:            usages,
+			ExpirationSeconds: ptr.To(expirationSeconds),
+		},
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, s.requestTimeout)
+	defer cancel()
+	return s.k8sClient.CertificatesV1().CertificateSigningRequests().Create(reqCtx, csr, meta_v1.CreateOptions{})
+}
+
+// delete deletes the CertificateSigningRequest name. It is not bound by the
+// context of the request, so that the CertificateSigningRequests which timed
+// out are deleted as well.
+func (s *CSRSigner) delete(scopedLog *logrus.Entry, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.requestTimeout)
+	defer cancel()
+	err := s.k8sClient.CertificatesV1().CertificateSigningRequests().Delete(ctx, name, meta_v1.DeleteOptions{})
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		scopedLog.WithError(err).Warn("Failed to delete CertificateSigningRequest")
+		return
+	}
+	scopedLog.Debug("Deleted CertificateSigningRequest")
+}
+
+// approve sets the approved condition on csr.
+func (s *CSRSigner) approve(ctx context.Context, csr *certificatesv1.CertificateSigningRequest) error {
+	csr = csr.DeepCopy()
+	csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+		Type:           certificatesv1.CertificateApproved,
+		Status:         v1.ConditionTrue,
+		Reason:         csrApprovalReason,
+		Message:        "Auto-approved by " + ManagedBy,
+		LastUpdateTime: meta_v1.Now(),
+	})
+
+	reqCtx, cancel := context.WithTimeout(ctx, s.requestTimeout)
+	defer cancel()
+	_, err := s.k8sClient.CertificatesV1().CertificateSigningRequests().UpdateApproval(reqCtx, csr.Name, csr, meta_v1.UpdateOptions{})
+	return err
+}
+
+// wait polls the CertificateSigningRequest name until its certificate is
+// issued, it is denied or failed, or ctx is done.
+func (s *CSRSigner) wait(ctx context.Context, name string) ([]byte, error) {
+	ticker := time.NewTicker(csrPollInterval)
+	defer ticker.Stop()
+
+	for {
+		reqCtx, cancel := context.WithTimeout(ctx, s.requestTimeout)
+		csr, err := s.k8sClient.CertificatesV1().CertificateSigningRequests().Get(reqCtx, name, meta_v1.GetOptions{})
+		cancel()
+		switch {
+		case err != nil:
+			logger(ctx).WithError(err).WithField(logfields.K8sCSRName, name).Debug("Failed to get CertificateSigningRequest")
+		case len(csr.Status.Certificate) != 0:
+			return csr.Status.Certificate, nil
+		default:
+			for _, cond := range csr.Status.Conditions {
+				if (cond.Type == certificatesv1.CertificateDenied || cond.Type == certificatesv1.CertificateFailed) &&
+					cond.Status == v1.ConditionTrue {
+					return nil, fmt.Errorf("CertificateSigningRequest %s %s: %s: %s",
+						name, csrConditionVerb(cond.Type), cond.Reason, cond.Message)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out after %s waiting for CertificateSigningRequest %s to be issued by %s",
+				s.timeout, name, s.signerName)
+		case <-ticker.C:
+		}
+	}
+}
+
+// csrConditionVerb describes a terminal CertificateSigningRequest condition.
+func csrConditionVerb(t certificatesv1.RequestConditionType) string {
+	if t == certificatesv1.CertificateDenied {
+		return "denied"
+	}
+	return "failed"
+}
@@ -0,0 +1,262 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package generate
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/cloudflare/cfssl/helpers"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// encryptedPKCS8BlockType is the PEM block type of encrypted PKCS#8
+	// private keys.
+	encryptedPKCS8BlockType = "ENCRYPTED PRIVATE KEY"
+	// pbkdf2Iterations is the PBKDF2 iteration count used to encrypt keys.
+	pbkdf2Iterations = 100000
+)
+
+var (
+	oidPBES2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+// encryptedPrivateKeyInfo is the PKCS#8 EncryptedPrivateKeyInfo structure
+// (RFC 5208).
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// pbes2Params are the parameters of the PBES2 encryption scheme (RFC 8018).
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params are the parameters of the PBKDF2 key derivation function
+// (RFC 8018).
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// parsePrivateKeyPEM parses a PEM encoded private key, which may be encrypted
+// with passphrase, either as encrypted PKCS#8 or as legacy encrypted PEM.
+func parsePrivateKeyPEM(keyPEM, passphrase []byte) (crypto.Signer, error) {
+	block := keyBlock(keyPEM)
+	switch {
+	case block != nil && block.Type == encryptedPKCS8BlockType:
+		if len(passphrase) == 0 {
+			return nil, errors.New("private key is encrypted, but no passphrase is provided")
+		}
+		der, err := decryptPKCS8(block.Bytes, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		key, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse decrypted private key: %w", err)
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	case block != nil && strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED"):
+		if len(passphrase) == 0 {
+			return nil, errors.New("private key is encrypted, but no passphrase is provided")
+		}
+		return helpers.ParsePrivateKeyPEMWithPassword(keyPEM, passphrase)
+	}
+	return helpers.ParsePrivateKeyPEM(keyPEM)
+}
+
+// isEncryptedKeyPEM returns true if keyPEM is an encrypted private key.
+func isEncryptedKeyPEM(keyPEM []byte) bool {
+	block := keyBlock(keyPEM)
+	return block != nil && (block.Type == encryptedPKCS8BlockType || strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED"))
+}
+
+// keyBlock returns the first PEM block of keyPEM which is not EC parameters.
+func keyBlock(keyPEM []byte) *pem.Block {
+	for {
+		var block *pem.Block
+		block, keyPEM = pem.Decode(keyPEM)
+		if block == nil || block.Type != "EC PARAMETERS" {
+			return block
+		}
+	}
+}
+
+// encryptKeyPEM returns the PEM encoded private key keyPEM encrypted with
+// passphrase, or keyPEM as is if it is already encrypted.
+func encryptKeyPEM(keyPEM, passphrase []byte) ([]byte, error) {
+	if isEncryptedKeyPEM(keyPEM) {
+		return keyPEM, nil
+	}
+	key, err := helpers.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return encryptPrivateKey(key, passphrase)
+}
+
+// encryptPrivateKey encodes key as PEM encrypted PKCS#8, using PBES2 with
+// PBKDF2-HMAC-SHA256 and AES-256-CBC.
+func encryptPrivateKey(key crypto.Signer, passphrase []byte) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(pbkdf2.Key(passphrase, salt, pbkdf2Iterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	padding := block.BlockSize() - len(der)%block.BlockSize()
+	encrypted := append(der, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: pbkdf2Iterations,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivParams, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	schemeParams, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParams}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	info, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: schemeParams}},
+		EncryptedData: encrypted,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: encryptedPKCS8BlockType, Bytes: info}), nil
+}
+
+// decryptPKCS8 decrypts a DER encoded PKCS#8 EncryptedPrivateKeyInfo, which
+// must use PBES2 with PBKDF2 and AES-CBC or DES-EDE3-CBC, and returns the DER
+// encoded PKCS#8 PrivateKeyInfo.
+func decryptPKCS8(der, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted private key: %w", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported private key encryption scheme %s, only PBES2 is supported", info.Algorithm.Algorithm)
+	}
+	var scheme pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &scheme); err != nil {
+		return nil, fmt.Errorf("failed to parse PBES2 parameters: %w", err)
+	}
+	if !scheme.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation function %s, only PBKDF2 is supported", scheme.KeyDerivationFunc.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(scheme.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("failed to parse PBKDF2 parameters: %w", err)
+	}
+
+	var prf func() hash.Hash
+	switch alg := kdf.PRF.Algorithm; {
+	case len(alg) == 0, alg.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case alg.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	case alg.Equal(oidHMACWithSHA384):
+		prf = sha512.New384
+	case alg.Equal(oidHMACWithSHA512):
+		prf = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported PBKDF2 pseudorandom function %s", alg)
+	}
+
+	var newCipher func(key []byte) (cipher.Block, error)
+	var keyLen int
+	switch alg := scheme.EncryptionScheme.Algorithm; {
+	case alg.Equal(oidAES128CBC):
+		newCipher, keyLen = aes.NewCipher, 16
+	case alg.Equal(oidAES192CBC):
+		newCipher, keyLen = aes.NewCipher, 24
+	case alg.Equal(oidAES256CBC):
+		newCipher, keyLen = aes.NewCipher, 32
+	case alg.Equal(oidDESEDE3CBC):
+		newCipher, keyLen = des.NewTripleDESCipher, 24
+	default:
+		return nil, fmt.Errorf("unsupported private key cipher %s", alg)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(scheme.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, fmt.Errorf("failed to parse cipher IV: %w", err)
+	}
+
+	block, err := newCipher(pbkdf2.Key(passphrase, kdf.Salt, kdf.IterationCount, keyLen, prf))
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != block.BlockSize() || len(info.EncryptedData) == 0 || len(info.EncryptedData)%block.BlockSize() != 0 {
+		return nil, errors.New("invalid encrypted private key")
+	}
+	decrypted := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, info.EncryptedData)
+
+	// A wrong passphrase is detected by an invalid padding
+	padding := int(decrypted[len(decrypted)-1])
+	if padding == 0 || padding > block.BlockSize() ||
+		!bytes.Equal(decrypted[len(decrypted)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("failed to decrypt private key: incorrect passphrase")
+	}
+	return decrypted[:len(decrypted)-padding], nil
+}
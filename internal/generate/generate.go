@@ -7,9 +7,12 @@ import (
 	"context"
 	"crypto"
 	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"slices"
 	"time"
 
 	"github.com/cloudflare/cfssl/cli/genkey"
@@ -21,7 +24,6 @@ import (
 	"github.com/cloudflare/cfssl/signer/local"
 	"github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
-	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 
@@ -41,6 +43,10 @@ type Cert struct {
 	Name             string
 	Namespace        string
 	Hosts            []string
+	KeyAlgorithm     string
+	KeySize          int
+	Subject          Subject
+	Metadata         Metadata
 
 	CA        *CA
 	CertBytes []byte
@@ -71,18 +77,46 @@ func (c *Cert) WithHosts(hosts []string) *Cert {
 	return c
 }
 
+// WithKey modifies to use the given key algorithm and size instead of the
+// default (ECDSA P-256)
+func (c *Cert) WithKey(algorithm string, size int) *Cert {
+	c.KeyAlgorithm = algorithm
+	c.KeySize = size
+	return c
+}
+
+// WithSubject modifies to use the given subject fields in addition to the
+// CommonName
+func (c *Cert) WithSubject(subject Subject) *Cert {
+	c.Subject = subject
+	return c
+}
+
 // Generate the certificate and keyfile and populate c.CertBytes and c.CertKey
 func (c *Cert) Generate(ca *CA) error {
+	c.logRequest()
+	return c.generate(context.Background(), ca)
+}
+
+// logRequest logs the request of the certificate generation
+func (c *Cert) logRequest() {
 	log.WithFields(logrus.Fields{
 		logfields.CertCommonName:       c.CommonName,
 		logfields.CertValidityDuration: c.ValidityDuration,
 		logfields.CertUsage:            c.Usage,
 	}).Info("Creating CSR for certificate")
+}
 
+// generate generates the certificate and keyfile, without logging the request.
+// It is safe to call concurrently for different certificates issued by the
+// same CA.
+func (c *Cert) generate(ctx context.Context, ca *CA) error {
 	certRequest := &csr.CertificateRequest{
-		CN:         c.CommonName,
-		Hosts:      c.Hosts,
-		KeyRequest: csr.NewKeyRequest(),
+		CN:           c.CommonName,
+		Names:        c.Subject.names(),
+		SerialNumber: c.Subject.SerialNumber,
+		Hosts:        c.Hosts,
+		KeyRequest:   newKeyRequest(c.KeyAlgorithm, c.KeySize),
 	}
 
 	g := &csr.Generator{Validator: genkey.Validator}
@@ -91,6 +125,24 @@ func (c *Cert) Generate(ca *CA) error {
 		return err
 	}
 
+	var certBytes []byte
+	if ca.IsExternal() {
+		certBytes, err = ca.external.sign(ctx, c, ca, csrBytes)
+	} else {
+		certBytes, err = c.signLocal(ca, csrBytes)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.CA = ca
+	c.CertBytes = certBytes
+	c.KeyBytes = keyBytes
+	return nil
+}
+
+// signLocal signs the PEM encoded CSR of c with the key of ca.
+func (c *Cert) signLocal(ca *CA, csrBytes []byte) ([]byte, error) {
 	policy := &config.Signing{
 		Default: &config.SigningProfile{
 			Usage:  c.Usage,
@@ -100,60 +152,78 @@ func (c *Cert) Generate(ca *CA) error {
 	caCert, caSigner := ca.CACert, ca.CAKey
 	s, err := local.NewSigner(caSigner, caCert, signer.DefaultSigAlgo(caSigner), policy)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	signReq := signer.SignRequest{Request: string(csrBytes)}
-	certBytes, err := s.Sign(signReq)
-	if err != nil {
-		return err
+	return s.Sign(signReq)
+}
+
+// Certificate parses and returns the x509 certificate from c.CertBytes
+func (c *Cert) Certificate() (*x509.Certificate, error) {
+	if c.CertBytes == nil {
+		return nil, fmt.Errorf("certificate %s/%s is empty", c.Namespace, c.Name)
 	}
+	return helpers.ParseCertificatePEM(c.CertBytes)
+}
 
-	c.CA = ca
-	c.CertBytes = certBytes
-	c.KeyBytes = keyBytes
-	return nil
+// ChainBytes returns the certificate followed by the intermediate CA
+// certificates of its issuer, if any.
+func (c *Cert) ChainBytes() []byte {
+	return append(slices.Clone(c.CertBytes), c.CA.IssuerChainBytes()...)
 }
 
-// StoreAsSecret creates or updates the certificate and keyfile in a K8s secret
-func (c *Cert) StoreAsSecret(ctx context.Context, k8sClient *kubernetes.Clientset) error {
+// Secret returns the secret holding the certificate and keyfile
+func (c *Cert) Secret() (*v1.Secret, error) {
 	if c.CertBytes == nil || c.KeyBytes == nil {
-		return fmt.Errorf("cannot create secret %s/%s from empty certificate",
+		return nil, fmt.Errorf("cannot create secret %s/%s from empty certificate",
 			c.Namespace, c.Name)
 	}
 
-	scopedLog := log.WithFields(logrus.Fields{
-		logfields.K8sSecretNamespace: c.Namespace,
-		logfields.K8sSecretName:      c.Name,
-	})
-	scopedLog.Info("Creating K8s Secret")
+	cert, err := c.Certificate()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate %s/%s: %w", c.Namespace, c.Name, err)
+	}
+	labels, annotations := c.Metadata.secretMetadata(c.role(), cert, c.CA.CACert)
 
-	secret := &v1.Secret{
+	return &v1.Secret{
 		ObjectMeta: meta_v1.ObjectMeta{
-			Name:      c.Name,
-			Namespace: c.Namespace,
+			Name:        c.Name,
+			Namespace:   c.Namespace,
+			Labels:      labels,
+			Annotations: annotations,
 		},
 		Data: map[string][]byte{
-			"ca.crt":  c.CA.CACertBytes,
-			"tls.crt": c.CertBytes,
+			"ca.crt":  c.CA.BundleBytes(),
+			"tls.crt": c.ChainBytes(),
 			"tls.key": c.KeyBytes,
 		},
 		Type: v1.SecretTypeTLS,
-	}
+	}, nil
+}
 
-	k8sSecrets := k8sClient.CoreV1().Secrets(c.Namespace)
-	_, err := k8sSecrets.Create(ctx, secret, meta_v1.CreateOptions{})
-	if k8sErrors.IsAlreadyExists(err) {
-		scopedLog.Info("Secret already exists, updating it instead")
-		_, err = k8sSecrets.Update(ctx, secret, meta_v1.UpdateOptions{})
+// Store creates or updates the certificate and keyfile secret in sink
+func (c *Cert) Store(ctx context.Context, sink Sink) error {
+	secret, err := c.Secret()
+	if err != nil {
+		return err
 	}
-	return err
+	return sink.Store(ctx, secret, true)
+}
+
+// StoreAsSecret creates or updates the certificate and keyfile in a K8s secret
+func (c *Cert) StoreAsSecret(ctx context.Context, k8sClient *kubernetes.Clientset) error {
+	return c.Store(ctx, NewSecretSink(k8sClient))
 }
 
 // CA contains the data and metadata of the certificate authority
 type CA struct {
 	SecretName      string
 	SecretNamespace string
+	KeyAlgorithm    string
+	KeySize         int
+	Subject         Subject
+	Metadata        Metadata
 
 	CACertBytes []byte
 	CAKeyBytes  []byte
@@ -161,9 +231,37 @@ type CA struct {
 	CACert *x509.Certificate
 	CAKey  crypto.Signer
 
+	// ChainBytes contains the intermediate CA certificates between this CA
+	// and the root, if any. RootCertBytes contains the root CA certificate,
+	// and is only set if this is an intermediate CA.
+	ChainBytes    []byte
+	RootCertBytes []byte
+
+	// keyPassphrase is the passphrase of the CA key, if encrypted. If
+	// encryptKey is true, the CA key is stored encrypted with it.
+	keyPassphrase []byte
+	encryptKey    bool
+	// tlsKeys is true if the CA certificate and key are also stored in the
+	// tls.crt and tls.key keys of the CA secret, as read by the cert-manager
+	// CA issuer.
+	tlsKeys bool
+
+	// external is the signer issuing the certificates of this CA, if its key
+	// is not held by certgen.
+	external externalSigner
+
+	rotation         caRotation
 	loadedFromSecret bool
 }
 
+// externalSigner issues the certificates of a CA whose key is not held by
+// certgen.
+type externalSigner interface {
+	// sign returns the PEM encoded certificate issued for c, by the CA ca,
+	// from its PEM encoded CSR.
+	sign(ctx context.Context, c *Cert, ca *CA, csrPEM []byte) ([]byte, error)
+}
+
 // NewCA creates a new root CA blueprint
 func NewCA(secretName, secretNamespace string) *CA {
 	return &CA{
@@ -172,6 +270,29 @@ func NewCA(secretName, secretNamespace string) *CA {
 	}
 }
 
+// WithKey modifies to use the given key algorithm and size instead of the
+// default (ECDSA P-256) when generating the CA
+func (c *CA) WithKey(algorithm string, size int) *CA {
+	c.KeyAlgorithm = algorithm
+	c.KeySize = size
+	return c
+}
+
+// WithSubject modifies to use the given subject fields in addition to the
+// common name when generating the CA
+func (c *CA) WithSubject(subject Subject) *CA {
+	c.Subject = subject
+	return c
+}
+
+// WithKeyPassphrase modifies to decrypt the CA key with the given passphrase.
+// If encryptKey is true, the CA key is also encrypted with it when stored.
+func (c *CA) WithKeyPassphrase(passphrase []byte, encryptKey bool) *CA {
+	c.keyPassphrase = passphrase
+	c.encryptKey = encryptKey
+	return c
+}
+
 // loadKeyPair populates c.CACert/c.CAKey from c.CACertBytes/c.CAKeyBytes
 func (c *CA) loadKeyPair() error {
 	caCert, err := helpers.ParseCertificatePEM(c.CACertBytes)
@@ -179,7 +300,7 @@ func (c *CA) loadKeyPair() error {
 		return fmt.Errorf("failed to parse CA cert PEM: %w", err)
 	}
 
-	caKey, err := helpers.ParsePrivateKeyPEM(c.CAKeyBytes)
+	caKey, err := parsePrivateKeyPEM(c.CAKeyBytes, c.keyPassphrase)
 	if err != nil {
 		return fmt.Errorf("failed to parse CA key PEM: %w", err)
 	}
@@ -196,14 +317,24 @@ func (c *CA) LoadedFromSecret() bool {
 
 // IsEmpty returns true if this CA is empty
 func (c *CA) IsEmpty() bool {
-	return c.CAKey == nil && c.CACert == nil
+	return c.CAKey == nil && c.CACert == nil && c.external == nil
+}
+
+// IsExternal returns true if the certificates of this CA are issued by an
+// external signer, such as a remote cfssl server.
+func (c *CA) IsExternal() bool {
+	return c.external != nil
 }
 
 // Reset resets ca key and ca cert values, this is useful for reload or regeneration.
 func (c *CA) Reset() {
 	c.CAKey = nil
 	c.CACert = nil
+	c.ChainBytes = nil
+	c.RootCertBytes = nil
+	c.rotation = caRotation{}
 	c.loadedFromSecret = false
+	c.external = nil
 }
 
 // Generate the root certificate and keyfile. Populates c.CACertBytes and c.CAKeyBytes
@@ -214,12 +345,13 @@ func (c *CA) Generate(commonName string, validityDuration time.Duration) error {
 	}).Info("Creating CSR for certificate authority")
 
 	caCSR := &csr.CertificateRequest{
-		Names: []csr.Name{{C: "US", ST: "San Francisco", L: "CA", O: "Cilium", OU: "Cilium"}},
-		CN:    commonName,
+		Names:        c.Subject.names(),
+		SerialNumber: c.Subject.SerialNumber,
+		CN:           commonName,
 		CA: &csr.CAConfig{
 			Expiry: validityDuration.String(),
 		},
-		KeyRequest: csr.NewKeyRequest(),
+		KeyRequest: newKeyRequest(c.KeyAlgorithm, c.KeySize),
 	}
 	caCertBytes, _, caKeyBytes, err := initca.New(caCSR)
 	if err != nil {
@@ -228,11 +360,81 @@ func (c *CA) Generate(commonName string, validityDuration time.Duration) error {
 
 	c.CACertBytes = caCertBytes
 	c.CAKeyBytes = caKeyBytes
+	c.ChainBytes = nil
+	c.RootCertBytes = nil
+	c.rotation = caRotation{}
+	c.loadedFromSecret = false
+	return c.loadKeyPair()
+}
+
+// GenerateIntermediate generates an intermediate CA certificate and keyfile
+// signed by parent. Populates c.CACertBytes, c.CAKeyBytes, c.ChainBytes and
+// c.RootCertBytes
+func (c *CA) GenerateIntermediate(parent *CA, commonName string, validityDuration time.Duration) error {
+	log.WithFields(logrus.Fields{
+		logfields.CertCommonName:       commonName,
+		logfields.CertValidityDuration: validityDuration,
+	}).Info("Creating CSR for intermediate certificate authority")
+
+	if parent.CAKey == nil {
+		return errors.New("parent CA key is not available, intermediate CAs cannot be signed by an external signer")
+	}
+
+	caCSR := &csr.CertificateRequest{
+		Names:        c.Subject.names(),
+		SerialNumber: c.Subject.SerialNumber,
+		CN:           commonName,
+		CA:           &csr.CAConfig{PathLenZero: true},
+		KeyRequest:   newKeyRequest(c.KeyAlgorithm, c.KeySize),
+	}
+
+	g := &csr.Generator{Validator: genkey.Validator}
+	csrBytes, keyBytes, err := g.ProcessRequest(caCSR)
+	if err != nil {
+		return err
+	}
+
+	// Intermediate CAs can only issue leaf certificates.
+	policy := initca.CAPolicy()
+	policy.Default.Expiry = validityDuration
+	policy.Default.CAConstraint.MaxPathLenZero = true
+	s, err := local.NewSigner(parent.CAKey, parent.CACert, signer.DefaultSigAlgo(parent.CAKey), policy)
+	if err != nil {
+		return err
+	}
+
+	certBytes, err := s.Sign(signer.SignRequest{Request: string(csrBytes)})
+	if err != nil {
+		return err
+	}
+
+	c.CACertBytes = certBytes
+	c.CAKeyBytes = keyBytes
+	c.ChainBytes = parent.IssuerChainBytes()
+	c.RootCertBytes = parent.BundleBytes()
+	c.rotation = caRotation{}
 	c.loadedFromSecret = false
 	return c.loadKeyPair()
 }
 
+// IsIntermediate returns true if this CA is signed by another CA
+func (c *CA) IsIntermediate() bool {
+	return len(c.RootCertBytes) != 0
+}
+
+// IssuerChainBytes returns the intermediate CA certificates to be appended to
+// the certificates issued by this CA, which is empty for root CAs.
+func (c *CA) IssuerChainBytes() []byte {
+	if !c.IsIntermediate() {
+		return nil
+	}
+	return append(slices.Clone(c.CACertBytes), c.ChainBytes...)
+}
+
 // LoadFromFile populates c.CACertBytes and c.CAKeyBytes by reading them from file.
+// The cert file may contain the chain of the CA: the CA certificate first,
+// followed by its parent CAs up to the root, in which case the CA is loaded as
+// an intermediate CA.
 func (c *CA) LoadFromFile(caCertFile, caKeyFile string) error {
 	if caCertFile == "" || caKeyFile == "" {
 		return errors.New("path for CA key and cert file must both be provided if CA is not generated")
@@ -248,51 +450,156 @@ func (c *CA) LoadFromFile(caCertFile, caKeyFile string) error {
 		return fmt.Errorf("failed to load Hubble CA key file: %w", err)
 	}
 
+	chain, err := helpers.ParseCertificatesPEM(caCertBytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse CA cert file: %w", err)
+	}
+	if len(chain) == 0 {
+		return errors.New("CA cert file contains no certificate")
+	}
+	if err := verifyParents(chain); err != nil {
+		return fmt.Errorf("invalid CA chain in cert file: %w", err)
+	}
+
 	c.CACertBytes = caCertBytes
+	c.ChainBytes = nil
+	c.RootCertBytes = nil
+	if len(chain) > 1 {
+		c.CACertBytes = encodeCertificates(chain[:1])
+		c.ChainBytes = encodeCertificates(chain[1 : len(chain)-1])
+		c.RootCertBytes = encodeCertificates(chain[len(chain)-1:])
+		if root := chain[len(chain)-1]; root.CheckSignatureFrom(root) != nil {
+			log.WithField(logfields.CertCommonName, root.Subject.CommonName).
+				Warn("CA chain does not end with a self-signed root, its last certificate is used as trust anchor")
+		}
+	}
 	c.CAKeyBytes = caKeyBytes
 	c.loadedFromSecret = false
 	return c.loadKeyPair()
 }
 
-// StoreAsSecret creates or updates the CA certificate in a K8s secret
-//   - If force is true, the existing secret with same name in same namespace (if available) will be overwritten.
-//   - If force is false and there is existing secret with same name in same namespace, just
-//     throws IsAlreadyExists error to caller
-func (c *CA) StoreAsSecret(ctx context.Context, k8sClient *kubernetes.Clientset, force bool) error {
+// verifyParents checks that each certificate of chain is a CA signed by the
+// next one.
+func verifyParents(chain []*x509.Certificate) error {
+	for i, cert := range chain {
+		if !cert.IsCA {
+			return fmt.Errorf("certificate %q is not a CA", cert.Subject.CommonName)
+		}
+		if i == len(chain)-1 {
+			break
+		}
+		if err := cert.CheckSignatureFrom(chain[i+1]); err != nil {
+			return fmt.Errorf("certificate %q is not signed by the next certificate %q: %w",
+				cert.Subject.CommonName, chain[i+1].Subject.CommonName, err)
+		}
+	}
+	return nil
+}
+
+// encodeCertificates returns the PEM encoding of certs.
+func encodeCertificates(certs []*x509.Certificate) []byte {
+	var buf []byte
+	for _, cert := range certs {
+		buf = append(buf, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return buf
+}
+
+// Secret returns the secret holding the CA certificate and keyfile
+func (c *CA) Secret() (*v1.Secret, error) {
 	if c.CACertBytes == nil || c.CAKeyBytes == nil {
-		return fmt.Errorf("cannot create secret %s/%s from empty certificate",
+		return nil, fmt.Errorf("cannot create secret %s/%s from empty certificate",
 			c.SecretNamespace, c.SecretName)
 	}
 
-	scopedLog := log.WithFields(logrus.Fields{
-		logfields.K8sSecretNamespace: c.SecretNamespace,
-		logfields.K8sSecretName:      c.SecretName,
-	})
-	scopedLog.Info("Creating K8s Secret")
+	labels, annotations := c.Metadata.secretMetadata(RoleCA, c.CACert, c.issuerCert())
+	maps.Copy(annotations, c.rotation.annotations())
 
 	secret := &v1.Secret{
 		ObjectMeta: meta_v1.ObjectMeta{
-			Name:      c.SecretName,
-			Namespace: c.SecretNamespace,
+			Name:        c.SecretName,
+			Namespace:   c.SecretNamespace,
+			Labels:      labels,
+			Annotations: annotations,
 		},
 		Data: map[string][]byte{
 			"ca.crt": c.CACertBytes,
 			"ca.key": c.CAKeyBytes,
 		},
 	}
+	if c.IsIntermediate() {
+		secret.Data["root.crt"] = c.RootCertBytes
+		if len(c.ChainBytes) != 0 {
+			secret.Data["chain.crt"] = c.ChainBytes
+		}
+	}
+	for k, v := range c.rotation.data() {
+		secret.Data[k] = v
+	}
+	if c.tlsKeys {
+		secret.Data["tls.crt"] = append(c.IssuerChainBytes(), c.RootCertBytes...)
+		if !c.IsIntermediate() {
+			secret.Data["tls.crt"] = c.CACertBytes
+		}
+		secret.Data["tls.key"] = c.CAKeyBytes
+	}
+	if c.encryptKey {
+		for _, k := range []string{"ca.key", nextCAKeyKey} {
+			if len(secret.Data[k]) == 0 {
+				continue
+			}
+			keyBytes, err := encryptKeyPEM(secret.Data[k], c.keyPassphrase)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encrypt %s of secret %s/%s: %w", k, c.SecretNamespace, c.SecretName, err)
+			}
+			secret.Data[k] = keyBytes
+		}
+	}
+	return secret, nil
+}
+
+// Store creates or updates the CA certificate secret in sink
+//   - If force is true, the existing secret with same name in same namespace (if available) will be overwritten.
+//   - If force is false and there is existing secret with same name in same namespace, just
+//     throws IsAlreadyExists error to caller
+func (c *CA) Store(ctx context.Context, sink Sink, force bool) error {
+	secret, err := c.Secret()
+	if err != nil {
+		return err
+	}
+	return sink.Store(ctx, secret, force)
+}
+
+// StoreAsSecret creates or updates the CA certificate in a K8s secret, with
+// the same semantics as Store
+func (c *CA) StoreAsSecret(ctx context.Context, k8sClient *kubernetes.Clientset, force bool) error {
+	return c.Store(ctx, NewSecretSink(k8sClient), force)
+}
 
+// LoadCertFromSecret populates c.CACertBytes and c.CACert, but not the CA key,
+// by reading them from a secret. This allows checking certificates issued by
+// the CA without access to its key.
+func (c *CA) LoadCertFromSecret(ctx context.Context, k8sClient *kubernetes.Clientset) error {
 	k8sSecrets := k8sClient.CoreV1().Secrets(c.SecretNamespace)
-	_, err := k8sSecrets.Create(ctx, secret, meta_v1.CreateOptions{})
-	if k8sErrors.IsAlreadyExists(err) {
-		if force {
-			scopedLog.Info("Secret already exists, overwrite existing one instead")
-			_, err = k8sSecrets.Update(ctx, secret, meta_v1.UpdateOptions{})
-		} else {
-			scopedLog.Warn("Secret already exists")
-			return err
-		}
+	secret, err := k8sSecrets.Get(ctx, c.SecretName, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	if len(secret.Data["ca.crt"]) == 0 {
+		return fmt.Errorf("Secret %s/%s has no CA cert", c.SecretNamespace, c.SecretName)
 	}
-	return err
+
+	cert, err := helpers.ParseCertificatePEM(leafCertBytes(secret.Data["ca.crt"]))
+	if err != nil {
+		return fmt.Errorf("Secret %s/%s has an invalid CA cert: %w", c.SecretNamespace, c.SecretName, err)
+	}
+
+	c.CACertBytes = secret.Data["ca.crt"]
+	c.CACert = cert
+	c.ChainBytes = secret.Data["chain.crt"]
+	c.RootCertBytes = secret.Data["root.crt"]
+	return nil
 }
 
 // LoadFromSecret populates c.CACertBytes and c.CAKeyBytes by reading them from a secret
@@ -311,8 +618,16 @@ func (c *CA) LoadFromSecret(ctx context.Context, k8sClient *kubernetes.Clientset
 		return fmt.Errorf("Secret %s/%s has no CA key", c.SecretNamespace, c.SecretName)
 	}
 
+	rotation, err := loadRotation(secret.Annotations, secret.Data)
+	if err != nil {
+		return fmt.Errorf("Secret %s/%s has invalid CA rotation state: %w", c.SecretNamespace, c.SecretName, err)
+	}
+
 	c.CACertBytes = secret.Data["ca.crt"]
 	c.CAKeyBytes = secret.Data["ca.key"]
+	c.ChainBytes = secret.Data["chain.crt"]
+	c.RootCertBytes = secret.Data["root.crt"]
+	c.rotation = rotation
 
 	if err := c.loadKeyPair(); err != nil {
 		return err
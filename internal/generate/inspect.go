@@ -0,0 +1,299 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package generate
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/cloudflare/cfssl/helpers"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Status is the health of a stored CA or certificate.
+type Status string
+
+const (
+	// StatusOK means that the certificate is valid and not about to expire.
+	StatusOK Status = "ok"
+	// StatusExpiring means that the certificate is within the warning window
+	// before its expiry.
+	StatusExpiring Status = "expiring"
+	// StatusExpired means that the certificate has expired.
+	StatusExpired Status = "expired"
+	// StatusMissing means that the secret or its certificate does not exist.
+	StatusMissing Status = "missing"
+	// StatusInvalid means that the certificate cannot be parsed.
+	StatusInvalid Status = "invalid"
+	// StatusUntrusted means that the certificate chain does not verify
+	// against the current CA.
+	StatusUntrusted Status = "untrusted"
+)
+
+// usageNames are the names of the key usages reported by inspections, as used
+// in the certificate specs.
+var usageNames = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "signing"},
+	{x509.KeyUsageContentCommitment, "content commitment"},
+	{x509.KeyUsageKeyEncipherment, "key encipherment"},
+	{x509.KeyUsageDataEncipherment, "data encipherment"},
+	{x509.KeyUsageKeyAgreement, "key agreement"},
+	{x509.KeyUsageCertSign, "cert sign"},
+	{x509.KeyUsageCRLSign, "crl sign"},
+}
+
+// extUsageNames are the names of the extended key usages reported by
+// inspections, as used in the certificate specs.
+var extUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "any",
+	x509.ExtKeyUsageServerAuth:      "server auth",
+	x509.ExtKeyUsageClientAuth:      "client auth",
+	x509.ExtKeyUsageCodeSigning:     "code signing",
+	x509.ExtKeyUsageEmailProtection: "email protection",
+	x509.ExtKeyUsageTimeStamping:    "timestamping",
+	x509.ExtKeyUsageOCSPSigning:     "ocsp signing",
+}
+
+// Inspection describes a CA or certificate as stored in its secret.
+type Inspection struct {
+	Kind            string `json:"kind"`
+	Name            string `json:"name"`
+	SecretNamespace string `json:"secretNamespace,omitempty"`
+	SecretName      string `json:"secretName,omitempty"`
+	Status          Status `json:"status"`
+	Error           string `json:"error,omitempty"`
+
+	Subject   string     `json:"subject,omitempty"`
+	Hosts     []string   `json:"hosts,omitempty"`
+	KeyType   string     `json:"keyType,omitempty"`
+	Usages    []string   `json:"usages,omitempty"`
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+	NotAfter  *time.Time `json:"notAfter,omitempty"`
+	// Remaining is the lifetime left, negative once expired.
+	Remaining string `json:"remaining,omitempty"`
+
+	// IssuerFingerprint is the SHA-256 fingerprint of the issuer certificate,
+	// if found in the secret or among the current CAs.
+	IssuerFingerprint string `json:"issuerFingerprint,omitempty"`
+	// ChainVerified is true if the certificate chain verifies against the
+	// current CA.
+	ChainVerified bool `json:"chainVerified"`
+}
+
+// Healthy returns true if the certificate is present, valid and not within
+// the warning window.
+func (i *Inspection) Healthy() bool {
+	return i.Status == StatusOK
+}
+
+// Inspector inspects the CAs and certificates stored in secrets.
+type Inspector struct {
+	k8sClient  *kubernetes.Clientset
+	warnBefore RenewBefore
+	now        time.Time
+}
+
+// NewInspector creates a new inspector reading secrets with k8sClient, and
+// reporting certificates within warnBefore of their expiry.
+func NewInspector(k8sClient *kubernetes.Clientset, warnBefore RenewBefore) *Inspector {
+	return &Inspector{
+		k8sClient:  k8sClient,
+		warnBefore: warnBefore,
+		now:        time.Now(),
+	}
+}
+
+// InspectCA inspects the CA certificate stored in the secret of ca, unless
+// already loaded (e.g. from file). The certificates found in the secret are
+// loaded into ca, so that it can be passed to InspectCert.
+func (i *Inspector) InspectCA(ctx context.Context, name string, ca *CA) Inspection {
+	entry := Inspection{
+		Kind:            PlanKindCA,
+		Name:            name,
+		SecretNamespace: ca.SecretNamespace,
+		SecretName:      ca.SecretName,
+	}
+
+	if bundle := ca.bundleCerts(); len(bundle) != 0 {
+		// The CA of a CSR signer is only known by its bundle, whose first
+		// certificate is inspected.
+		i.inspect(&entry, bundle[0], bundle, nil, nil)
+		return entry
+	}
+	if ca.CACert == nil {
+		if err := ca.LoadCertFromSecret(ctx, i.k8sClient); err != nil {
+			entry.fail(err)
+			return entry
+		}
+	}
+
+	roots := []*x509.Certificate{ca.CACert}
+	var intermediates []*x509.Certificate
+	if ca.IsIntermediate() {
+		roots, _ = helpers.ParseCertificatesPEM(ca.RootCertBytes)
+		intermediates, _ = helpers.ParseCertificatesPEM(ca.ChainBytes)
+	}
+	i.inspect(&entry, ca.CACert, roots, intermediates, nil)
+	return entry
+}
+
+// InspectCert inspects the certificate stored in the secret of c, which must
+// verify against ca, as returned by InspectCA.
+func (i *Inspector) InspectCert(ctx context.Context, name string, c *Cert, ca *CA) Inspection {
+	entry := Inspection{
+		Kind:            PlanKindCertificate,
+		Name:            name,
+		SecretNamespace: c.Namespace,
+		SecretName:      c.Name,
+	}
+
+	data, err := i.secretData(ctx, c.Namespace, c.Name)
+	if err != nil {
+		entry.fail(err)
+		return entry
+	}
+	if len(data["tls.crt"]) == 0 {
+		entry.Status = StatusMissing
+		entry.Error = "secret has no certificate"
+		return entry
+	}
+	chain, err := helpers.ParseCertificatesPEM(data["tls.crt"])
+	if err != nil {
+		entry.fail(err)
+		return entry
+	}
+
+	var roots []*x509.Certificate
+	if ca != nil && ca.CACert != nil {
+		roots = append(roots, ca.CACert)
+		if !ca.IsIntermediate() {
+			// During a CA rotation, the certificates issued by the other
+			// CA of the bundle are still trusted.
+			bundle, _ := helpers.ParseCertificatesPEM(ca.BundleBytes())
+			roots = append(roots, bundle...)
+		}
+	} else if ca != nil {
+		roots = ca.bundleCerts()
+	}
+	// The CA bundle of the secret may contain the issuer if it is not the
+	// current CA anymore.
+	bundle, _ := helpers.ParseCertificatesPEM(data["ca.crt"])
+	i.inspect(&entry, chain[0], roots, chain[1:], bundle)
+	return entry
+}
+
+// secretData returns the data of the given secret.
+func (i *Inspector) secretData(ctx context.Context, namespace, name string) (map[string][]byte, error) {
+	secret, err := i.k8sClient.CoreV1().Secrets(namespace).Get(ctx, name, meta_v1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return secret.Data, nil
+}
+
+// fail marks the inspection as missing or invalid, depending on err.
+func (i *Inspection) fail(err error) {
+	i.Status = StatusInvalid
+	if k8sErrors.IsNotFound(err) {
+		i.Status = StatusMissing
+	}
+	i.Error = err.Error()
+}
+
+// inspect fills entry with the details of cert, and verifies it against roots.
+// The issuer of cert is looked up in roots, intermediates and others.
+func (i *Inspector) inspect(entry *Inspection, cert *x509.Certificate, roots, intermediates, others []*x509.Certificate) {
+	entry.Subject = cert.Subject.String()
+	entry.Hosts = certHosts(cert)
+	entry.KeyType = keyType(cert)
+	entry.Usages = certUsages(cert)
+	entry.NotBefore = &cert.NotBefore
+	entry.NotAfter = &cert.NotAfter
+	entry.Remaining = cert.NotAfter.Sub(i.now).Truncate(time.Second).String()
+
+	switch {
+	case !i.now.Before(cert.NotAfter):
+		entry.Status = StatusExpired
+	case !i.now.Before(i.warnBefore.RenewalTime(cert)):
+		entry.Status = StatusExpiring
+	default:
+		entry.Status = StatusOK
+	}
+
+	for _, candidates := range [][]*x509.Certificate{roots, intermediates, others} {
+		for _, issuer := range candidates {
+			if entry.IssuerFingerprint == "" && cert.CheckSignatureFrom(issuer) == nil {
+				entry.IssuerFingerprint = Fingerprint(issuer)
+			}
+		}
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		CurrentTime:   i.now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, root := range roots {
+		opts.Roots.AddCert(root)
+	}
+	for _, intermediate := range intermediates {
+		opts.Intermediates.AddCert(intermediate)
+	}
+	if _, err := cert.Verify(opts); err != nil {
+		entry.Error = fmt.Sprintf("chain verification failed: %s", err)
+		if entry.Status != StatusExpired {
+			entry.Status = StatusUntrusted
+		}
+		return
+	}
+	entry.ChainVerified = true
+}
+
+// Fingerprint returns the hex-encoded SHA-256 fingerprint of cert.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// keyType returns the algorithm and size of the public key of cert.
+func keyType(cert *x509.Certificate) string {
+	switch pub := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		return "ECDSA " + pub.Curve.Params().Name
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", pub.N.BitLen())
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return cert.PublicKeyAlgorithm.String()
+}
+
+// certUsages returns the key usages and extended key usages of cert.
+func certUsages(cert *x509.Certificate) []string {
+	var usages []string
+	for _, u := range usageNames {
+		if cert.KeyUsage&u.usage != 0 {
+			usages = append(usages, u.name)
+		}
+	}
+	for _, u := range cert.ExtKeyUsage {
+		if name, ok := extUsageNames[u]; ok {
+			usages = append(usages, name)
+		}
+	}
+	return usages
+}
@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package generate
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+	"slices"
+
+	"github.com/cloudflare/cfssl/csr"
+)
+
+const (
+	// KeyAlgorithmECDSA generates ECDSA keys on the P-256, P-384 or P-521
+	// curves, selected by the key size.
+	KeyAlgorithmECDSA = "ecdsa"
+	// KeyAlgorithmRSA generates RSA keys of 2048, 3072 or 4096 bits.
+	KeyAlgorithmRSA = "rsa"
+	// KeyAlgorithmEd25519 generates Ed25519 keys, which have no size.
+	KeyAlgorithmEd25519 = "ed25519"
+)
+
+// keySizes are the supported key sizes for each key algorithm, the first one
+// being the default.
+var keySizes = map[string][]int{
+	KeyAlgorithmECDSA:   {256, 384, 521},
+	KeyAlgorithmRSA:     {2048, 3072, 4096},
+	KeyAlgorithmEd25519: {0},
+}
+
+// ValidateKey returns an error if algorithm and size do not describe a
+// supported key. A zero size selects the default size of the algorithm.
+func ValidateKey(algorithm string, size int) error {
+	sizes, ok := keySizes[algorithm]
+	if !ok {
+		return fmt.Errorf("unsupported key algorithm %q: must be one of %s, %s or %s",
+			algorithm, KeyAlgorithmECDSA, KeyAlgorithmRSA, KeyAlgorithmEd25519)
+	}
+	if size != 0 && !slices.Contains(sizes, size) {
+		if algorithm == KeyAlgorithmEd25519 {
+			return fmt.Errorf("invalid key size %d: %s keys have no size", size, algorithm)
+		}
+		return fmt.Errorf("invalid key size %d for %s keys: must be one of %v", size, algorithm, sizes)
+	}
+	return nil
+}
+
+// newKeyRequest returns the key request for the given algorithm and size,
+// defaulting to ECDSA P-256 if no algorithm is set.
+func newKeyRequest(algorithm string, size int) *csr.KeyRequest {
+	if algorithm == "" {
+		return csr.NewKeyRequest()
+	}
+	if size == 0 {
+		size = keySizes[algorithm][0]
+	}
+	return &csr.KeyRequest{A: algorithm, S: size}
+}
+
+// keyMatches returns true if pub is a key of the given algorithm and size.
+func keyMatches(pub crypto.PublicKey, algorithm string, size int) bool {
+	kr := newKeyRequest(algorithm, size)
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		return kr.A == KeyAlgorithmECDSA && pub.Curve.Params().BitSize == kr.S
+	case *rsa.PublicKey:
+		return kr.A == KeyAlgorithmRSA && pub.N.BitLen() == kr.S
+	case ed25519.PublicKey:
+		return kr.A == KeyAlgorithmEd25519
+	}
+	return false
+}
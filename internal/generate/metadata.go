@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package generate
+
+import (
+	"crypto/x509"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/cloudflare/cfssl/helpers"
+
+	"github.com/cilium/certgen/internal/version"
+)
+
+const (
+	// LabelManagedBy is the standard label identifying the tool managing
+	// the secrets.
+	LabelManagedBy = "app.kubernetes.io/managed-by"
+	// ManagedBy is the value of LabelManagedBy on the secrets stored by
+	// certgen.
+	ManagedBy = "cilium-certgen"
+	// LabelComponent is the standard label identifying the component using
+	// the certificate (e.g. hubble-server), or the name of the CA.
+	LabelComponent = "app.kubernetes.io/component"
+	// LabelRole is the label describing the role of the certificate, one of
+	// the Role* values.
+	LabelRole = "certgen.cilium.io/role"
+
+	// annotationPrefix is the prefix of the annotations owned by certgen.
+	annotationPrefix = "certgen.cilium.io/"
+	// AnnotationIssuedAt is the annotation storing when the certificate was
+	// issued (its NotBefore), in RFC 3339 format.
+	AnnotationIssuedAt = annotationPrefix + "issued-at"
+	// AnnotationNotAfter is the annotation storing when the certificate
+	// expires, in RFC 3339 format.
+	AnnotationNotAfter = annotationPrefix + "not-after"
+	// AnnotationSerialNumber is the annotation storing the hex-encoded serial
+	// number of the certificate.
+	AnnotationSerialNumber = annotationPrefix + "serial-number"
+	// AnnotationFingerprint is the annotation storing the SHA-256
+	// fingerprint of the certificate.
+	AnnotationFingerprint = annotationPrefix + "fingerprint-sha256"
+	// AnnotationCAFingerprint is the annotation storing the SHA-256
+	// fingerprint of the CA which issued the certificate.
+	AnnotationCAFingerprint = annotationPrefix + "ca-fingerprint-sha256"
+	// AnnotationVersion is the annotation storing the certgen version which
+	// wrote the secret.
+	AnnotationVersion = annotationPrefix + "version"
+)
+
+const (
+	// RoleCA is the role of CA certificates.
+	RoleCA = "ca"
+	// RoleServer is the role of certificates only used by TLS servers.
+	RoleServer = "server"
+	// RoleClient is the role of certificates only used by TLS clients.
+	RoleClient = "client"
+	// RoleServerClient is the role of certificates used by both TLS servers
+	// and clients.
+	RoleServerClient = "server-client"
+	// RoleOther is the role of certificates used neither for server nor
+	// client authentication.
+	RoleOther = "other"
+)
+
+// Metadata are the labels and annotations set on a secret, in addition to the
+// ones set by certgen.
+type Metadata struct {
+	// Component is the value of the LabelComponent label.
+	Component   string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// WithMetadata modifies to set the given labels and annotations on the secret
+func (c *Cert) WithMetadata(metadata Metadata) *Cert {
+	c.Metadata = metadata
+	return c
+}
+
+// WithMetadata modifies to set the given labels and annotations on the secret
+func (c *CA) WithMetadata(metadata Metadata) *CA {
+	c.Metadata = metadata
+	return c
+}
+
+// role returns the role of the certificate, based on its usages.
+func (c *Cert) role() string {
+	server := slices.Contains(c.Usage, "server auth")
+	client := slices.Contains(c.Usage, "client auth")
+	switch {
+	case server && client:
+		return RoleServerClient
+	case server:
+		return RoleServer
+	case client:
+		return RoleClient
+	}
+	return RoleOther
+}
+
+// issuerCert returns the certificate of the CA which issued this CA.
+func (c *CA) issuerCert() *x509.Certificate {
+	if !c.IsIntermediate() {
+		return c.CACert
+	}
+	parent := c.ChainBytes
+	if len(parent) == 0 {
+		parent = c.RootCertBytes
+	}
+	cert, err := helpers.ParseCertificatePEM(leafCertBytes(parent))
+	if err != nil {
+		return nil
+	}
+	return cert
+}
+
+// secretMetadata returns the labels and annotations of a secret holding cert,
+// issued by issuer, with the given role.
+func (m Metadata) secretMetadata(role string, cert, issuer *x509.Certificate) (labels, annotations map[string]string) {
+	labels = maps.Clone(m.Labels)
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[LabelManagedBy] = ManagedBy
+	labels[LabelRole] = role
+	if m.Component != "" {
+		labels[LabelComponent] = m.Component
+	}
+
+	annotations = maps.Clone(m.Annotations)
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[AnnotationVersion] = version.Version
+	if cert != nil {
+		annotations[AnnotationIssuedAt] = cert.NotBefore.UTC().Format(time.RFC3339)
+		annotations[AnnotationNotAfter] = cert.NotAfter.UTC().Format(time.RFC3339)
+		annotations[AnnotationSerialNumber] = fmt.Sprintf("%x", cert.SerialNumber)
+		annotations[AnnotationFingerprint] = Fingerprint(cert)
+	}
+	if issuer != nil {
+		annotations[AnnotationCAFingerprint] = Fingerprint(issuer)
+	}
+	return labels, annotations
+}
@@ -0,0 +1,186 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package generate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	cfsslLog "github.com/cloudflare/cfssl/log"
+	"github.com/sirupsen/logrus"
+
+	"github.com/cilium/certgen/internal/logging"
+	"github.com/cilium/certgen/internal/logging/logfields"
+)
+
+// Workers returns the number of concurrent workers to use for the given
+// configured value: the number of CPUs if it is not positive.
+func Workers(workers int) int {
+	if workers <= 0 {
+		return runtime.NumCPU()
+	}
+	return workers
+}
+
+// GenerateAll generates the certificates, certs[i] being issued by cas[i],
+// using up to workers concurrent workers. The certificate requests are logged
+// in order before any is generated, and the entries logged while generating
+// each certificate are logged in order as well. If generations fail, the error
+// of the first failed certificate (in order) is returned, and the certificates
+// which were not started yet are not generated.
+func GenerateAll(certs []*Cert, cas []*CA, workers int) error {
+	for _, c := range certs {
+		c.logRequest()
+	}
+	if concurrent(len(certs), workers) {
+		// cfssl logs through a global logger whose entries cannot be ordered,
+		// so only its warnings are kept while generating concurrently.
+		level := cfsslLog.Level
+		cfsslLog.Level = cfsslLog.LevelWarning
+		defer func() { cfsslLog.Level = level }()
+	}
+	return parallelize(len(certs), workers, func(ctx context.Context, i int) error {
+		c := certs[i]
+		if err := c.generate(ctx, cas[i]); err != nil {
+			return fmt.Errorf("failed to generate certificate for secret %s/%s: %w",
+				c.Namespace, c.Name, err)
+		}
+		if cert, err := c.Certificate(); err == nil {
+			logger(ctx).WithFields(logrus.Fields{
+				logfields.CertCommonName:   c.CommonName,
+				logfields.CertSerialNumber: cert.SerialNumber,
+			}).Info("Generated certificate")
+		}
+		return nil
+	})
+}
+
+// StoreAll stores the certificates in sink, using up to workers concurrent
+// workers. Each store is bounded by requestTimeout. Concurrent requests to the
+// K8s API remain subject to the rate limits of the K8s client. The entries
+// logged while storing each certificate are logged in order. If stores fail,
+// the error of the first failed certificate (in order) is returned, and the
+// certificates which were not started yet are not stored.
+func StoreAll(certs []*Cert, sink Sink, workers int, requestTimeout time.Duration) error {
+	return parallelize(len(certs), workers, func(ctx context.Context, i int) error {
+		ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+		if err := certs[i].Store(ctx, sink); err != nil {
+			return fmt.Errorf("failed to create secret %s/%s: %w", certs[i].Namespace, certs[i].Name, err)
+		}
+		return nil
+	})
+}
+
+// concurrent returns whether n calls are run concurrently with the given
+// configured number of workers.
+func concurrent(n, workers int) bool {
+	return min(Workers(workers), n) > 1
+}
+
+// loggerKey is the context key of the logger of a call run by parallelize.
+type loggerKey struct{}
+
+// logger returns the logger to use in ctx: the one buffering the entries of
+// the call run by parallelize, if any, or the package logger.
+func logger(ctx context.Context) *logrus.Entry {
+	if l, ok := ctx.Value(loggerKey{}).(*logrus.Entry); ok {
+		return l
+	}
+	return log
+}
+
+// bufferedLogger returns a logger formatting entries like the package logger,
+// but writing them to buf.
+func bufferedLogger(buf *bytes.Buffer) *logrus.Entry {
+	l := logrus.New()
+	l.Out = buf
+	l.Formatter = logging.DefaultLogger.Formatter
+	l.Level = logging.DefaultLogger.GetLevel()
+	return l.WithFields(log.Data)
+}
+
+// parallelize calls fn for every index in [0, n) using up to workers
+// concurrent goroutines. Once a call fails, no further call is started. It
+// returns the error of the lowest failed index, so that the reported error
+// does not depend on scheduling. For the same reason, when calls run
+// concurrently, the entries logged through logger(ctx) are buffered per call
+// and written in index order, as soon as the calls of the lower indexes are
+// done.
+func parallelize(n, workers int, fn func(ctx context.Context, i int) error) error {
+	if !concurrent(n, workers) {
+		for i := range n {
+			if err := fn(context.Background(), i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	errs := make([]error, n)
+	bufs := make([]*bytes.Buffer, n)
+	done := make([]bool, n)
+	indexes := make(chan int)
+
+	var mu sync.Mutex
+	failed := false
+	flushed := 0
+	// flush writes the buffered entries of the calls done in index order. It
+	// must be called with mu held.
+	flush := func() {
+		for ; flushed < n && done[flushed]; flushed++ {
+			logging.DefaultLogger.Out.Write(bufs[flushed].Bytes())
+		}
+	}
+
+	var wg sync.WaitGroup
+	for range min(Workers(workers), n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				buf := &bytes.Buffer{}
+				ctx := context.WithValue(context.Background(), loggerKey{}, bufferedLogger(buf))
+				err := fn(ctx, i)
+
+				mu.Lock()
+				failed = failed || err != nil
+				errs[i], bufs[i], done[i] = err, buf, true
+				flush()
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := range n {
+		mu.Lock()
+		stop := failed
+		mu.Unlock()
+		if stop {
+			break
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	// The calls which were not started after a failure are skipped.
+	for i := range n {
+		if !done[i] {
+			done[i], bufs[i] = true, &bytes.Buffer{}
+		}
+	}
+	flush()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
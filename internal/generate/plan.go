@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package generate
+
+import (
+	"context"
+	"encoding/hex"
+	"slices"
+	"time"
+
+	"github.com/cloudflare/cfssl/helpers"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// PlanKindCA denotes plan entries about CA secrets.
+	PlanKindCA = "ca"
+	// PlanKindCertificate denotes plan entries about certificate secrets.
+	PlanKindCertificate = "certificate"
+)
+
+// PlanEntry describes what certgen would do to the secret of a CA or
+// certificate, as computed in dry-run mode.
+type PlanEntry struct {
+	Kind            string `json:"kind"`
+	Name            string `json:"name"`
+	SecretNamespace string `json:"secretNamespace"`
+	SecretName      string `json:"secretName"`
+	Action          Action `json:"action"`
+	Reason          string `json:"reason,omitempty"`
+
+	// AddedHosts and RemovedHosts are the SANs which would be added to and
+	// removed from the existing certificate.
+	AddedHosts   []string `json:"addedHosts,omitempty"`
+	RemovedHosts []string `json:"removedHosts,omitempty"`
+
+	// CurrentIssuer, CurrentIssuerKeyID and CurrentNotAfter describe the
+	// existing certificate, Issuer, IssuerKeyID and NotAfter the certificate
+	// after the run. The key IDs are hex encoded, and tell apart CAs with the
+	// same common name, e.g. a regenerated CA.
+	CurrentIssuer      string     `json:"currentIssuer,omitempty"`
+	CurrentIssuerKeyID string     `json:"currentIssuerKeyID,omitempty"`
+	Issuer             string     `json:"issuer,omitempty"`
+	IssuerKeyID        string     `json:"issuerKeyID,omitempty"`
+	CurrentNotAfter    *time.Time `json:"currentNotAfter,omitempty"`
+	NotAfter           *time.Time `json:"notAfter,omitempty"`
+}
+
+// IssuerChanged returns true if the certificate would be issued by another
+// CA than the existing one. The CAs are compared by key ID if known, and by
+// common name otherwise.
+func (e *PlanEntry) IssuerChanged() bool {
+	if e.CurrentIssuer == "" {
+		return false
+	}
+	if e.CurrentIssuerKeyID != "" && e.IssuerKeyID != "" {
+		return e.CurrentIssuerKeyID != e.IssuerKeyID
+	}
+	return e.CurrentIssuer != e.Issuer
+}
+
+// Changed returns true if the entry would modify its secret.
+func (e *PlanEntry) Changed() bool {
+	return e.Action != ActionKept
+}
+
+// PlanEntry returns the plan entry for the CA, which would be subject to
+// action.
+func (c *CA) PlanEntry(name string, action Action, reason string) PlanEntry {
+	entry := PlanEntry{
+		Kind:            PlanKindCA,
+		Name:            name,
+		SecretNamespace: c.SecretNamespace,
+		SecretName:      c.SecretName,
+		Action:          action,
+		Reason:          reason,
+	}
+	if c.CACert != nil {
+		entry.Issuer = c.CACert.Issuer.CommonName
+		entry.IssuerKeyID = hex.EncodeToString(c.CACert.AuthorityKeyId)
+		entry.NotAfter = &c.CACert.NotAfter
+	}
+	return entry
+}
+
+// Plan reads the existing secret of the certificate and returns what certgen
+// would do to it, without generating or storing anything. If reuse is false,
+// existing certificates are always renewed, as in the default mode.
+func (c *Cert) Plan(ctx context.Context, k8sClient *kubernetes.Clientset, ca *CA, renewBefore RenewBefore, reuse bool) (PlanEntry, error) {
+	entry := PlanEntry{
+		Kind:            PlanKindCertificate,
+		SecretNamespace: c.Namespace,
+		SecretName:      c.Name,
+	}
+	if ca.CACert != nil {
+		entry.Issuer = ca.CACert.Subject.CommonName
+		entry.IssuerKeyID = hex.EncodeToString(ca.CACert.SubjectKeyId)
+	}
+	notAfter := time.Now().Add(c.ValidityDuration)
+
+	secret, err := k8sClient.CoreV1().Secrets(c.Namespace).Get(ctx, c.Name, meta_v1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		entry.Action = ActionCreated
+		entry.AddedHosts = sortedHosts(c.Hosts)
+		entry.NotAfter = &notAfter
+		return entry, nil
+	}
+	if err != nil {
+		return PlanEntry{}, err
+	}
+
+	entry.Action, entry.Reason = c.renewalAction(secret.Data, ca, renewBefore)
+	if !reuse && entry.Action != ActionRenewed {
+		entry.Action, entry.Reason = ActionRenewed, "certificates are regenerated on every run"
+	}
+
+	current, err := helpers.ParseCertificatePEM(leafCertBytes(secret.Data["tls.crt"]))
+	if err != nil {
+		entry.NotAfter = &notAfter
+		return entry, nil
+	}
+	entry.CurrentIssuer = current.Issuer.CommonName
+	entry.CurrentIssuerKeyID = hex.EncodeToString(current.AuthorityKeyId)
+	entry.CurrentNotAfter = &current.NotAfter
+	if entry.Action != ActionRenewed {
+		entry.Issuer = entry.CurrentIssuer
+		entry.IssuerKeyID = entry.CurrentIssuerKeyID
+		entry.NotAfter = entry.CurrentNotAfter
+		return entry, nil
+	}
+
+	entry.NotAfter = &notAfter
+	entry.AddedHosts, entry.RemovedHosts = diffHosts(certHosts(current), c.Hosts)
+	return entry, nil
+}
+
+// diffHosts returns the hosts which are in to but not in from, and the ones
+// which are in from but not in to.
+func diffHosts(from, to []string) (added, removed []string) {
+	from, to = sortedHosts(from), sortedHosts(to)
+	for _, host := range to {
+		if !slices.Contains(from, host) {
+			added = append(added, host)
+		}
+	}
+	for _, host := range from {
+		if !slices.Contains(to, host) {
+			removed = append(removed, host)
+		}
+	}
+	return added, removed
+}
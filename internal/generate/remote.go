@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package generate
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cloudflare/cfssl/api/client"
+	"github.com/cloudflare/cfssl/auth"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/info"
+	"github.com/cloudflare/cfssl/signer"
+	"github.com/sirupsen/logrus"
+
+	"github.com/cilium/certgen/internal/logging/logfields"
+)
+
+// RemoteSigner signs certificates with a remote cfssl server, through its
+// sign endpoint, or its authsign endpoint if an auth key is set.
+type RemoteSigner struct {
+	remote   client.Remote
+	provider auth.Provider
+	profile  string
+	label    string
+}
+
+// NewRemoteSigner creates a signer sending requests to the cfssl server at url
+// (or to the first available one of a comma-separated list of servers), with
+// the given signing profile and label, which may be empty to use the server
+// defaults. If authKey is not empty, it is the hex encoded key authenticating
+// the requests. tlsConfig is used to connect to https servers, and may be nil
+// to use the system roots. timeout bounds each request.
+func NewRemoteSigner(url string, tlsConfig *tls.Config, timeout time.Duration, profile, label, authKey string) (*RemoteSigner, error) {
+	remote := client.NewServerTLS(url, tlsConfig)
+	if remote == nil {
+		return nil, fmt.Errorf("invalid remote signer URL %q", url)
+	}
+	remote.SetRequestTimeout(timeout)
+
+	s := &RemoteSigner{
+		remote:  remote,
+		profile: profile,
+		label:   label,
+	}
+	if authKey != "" {
+		provider, err := auth.New(authKey, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid remote signer auth key: %w", err)
+		}
+		s.provider = provider
+	}
+	return s, nil
+}
+
+// Hosts returns the URLs of the cfssl servers.
+func (s *RemoteSigner) Hosts() []string {
+	return s.remote.Hosts()
+}
+
+// Info returns the PEM encoded certificate of the CA signing the requests for
+// the profile and label of s.
+func (s *RemoteSigner) Info() ([]byte, error) {
+	req, err := json.Marshal(info.Req{Label: s.label, Profile: s.profile})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.remote.Info(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Certificate == "" {
+		return nil, errors.New("remote signer returned no CA certificate")
+	}
+	return []byte(resp.Certificate), nil
+}
+
+// Sign sends the PEM encoded CSR to the cfssl server and returns the PEM
+// encoded certificate it issued for hosts. The usages and validity of the
+// certificate are set by the signing profile of the server.
+func (s *RemoteSigner) Sign(csrPEM []byte, hosts []string) ([]byte, error) {
+	req, err := json.Marshal(signer.SignRequest{
+		Hosts:   hosts,
+		Request: string(csrPEM),
+		Profile: s.profile,
+		Label:   s.label,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s.provider != nil {
+		return s.remote.AuthSign(req, nil, s.provider)
+	}
+	return s.remote.Sign(req)
+}
+
+// LoadFromRemote populates c.CACertBytes and c.CACert with the certificate of
+// the CA of the remote signer s, which then issues the certificates of this
+// CA instead of a local key. If chainBytes is not empty, it contains the parent
+// CA certificates of the remote CA up to the root, and the remote CA is
+// handled as an intermediate CA.
+func (c *CA) LoadFromRemote(s *RemoteSigner, chainBytes []byte) error {
+	caCertBytes, err := s.Info()
+	if err != nil {
+		return fmt.Errorf("failed to get CA certificate from remote signer: %w", err)
+	}
+	caCertBytes = leafCertBytes(caCertBytes)
+	caCert, err := helpers.ParseCertificatePEM(caCertBytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse remote CA cert PEM: %w", err)
+	}
+
+	c.ChainBytes = nil
+	c.RootCertBytes = nil
+	if len(chainBytes) != 0 {
+		chain, err := helpers.ParseCertificatesPEM(chainBytes)
+		if err != nil {
+			return fmt.Errorf("failed to parse remote CA chain: %w", err)
+		}
+		if len(chain) == 0 {
+			return errors.New("remote CA chain contains no certificate")
+		}
+		if err := verifyParents(append([]*x509.Certificate{caCert}, chain...)); err != nil {
+			return fmt.Errorf("invalid remote CA chain: %w", err)
+		}
+		c.ChainBytes = encodeCertificates(chain[:len(chain)-1])
+		c.RootCertBytes = encodeCertificates(chain[len(chain)-1:])
+	}
+
+	c.CACertBytes = caCertBytes
+	c.CAKeyBytes = nil
+	c.CACert = caCert
+	c.CAKey = nil
+	c.rotation = caRotation{}
+	c.loadedFromSecret = false
+	c.external = s
+	return nil
+}
+
+// sign implements externalSigner, checking that the returned certificate is
+// issued by the remote CA.
+func (s *RemoteSigner) sign(ctx context.Context, c *Cert, ca *CA, csrPEM []byte) ([]byte, error) {
+	certBytes, err := s.Sign(csrPEM, c.Hosts)
+	if err != nil {
+		return nil, fmt.Errorf("remote signer failed to sign certificate: %w", err)
+	}
+	cert, err := helpers.ParseCertificatePEM(certBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate returned by remote signer: %w", err)
+	}
+	if err := cert.CheckSignatureFrom(ca.CACert); err != nil {
+		return nil, fmt.Errorf("certificate returned by remote signer is not issued by remote CA %q: %w",
+			ca.CACert.Subject.CommonName, err)
+	}
+	if missing, _ := diffExtKeyUsages(cert, c.Usage); len(missing) != 0 {
+		logger(ctx).WithFields(logrus.Fields{
+			logfields.CertCommonName: c.CommonName,
+			logfields.CertUsage:      missing,
+		}).Warn("Certificate issued by remote signer lacks extended key usages, check the remote signing profile")
+	}
+	return certBytes, nil
+}
@@ -0,0 +1,304 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package generate
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/auth"
+	"github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/signer"
+	"github.com/cloudflare/cfssl/signer/local"
+)
+
+// testAuthKey is the hex encoded key authenticating the requests to
+// cfsslServer, if it requires authentication.
+var testAuthKey = hex.EncodeToString([]byte("0123456789abcdef"))
+
+// cfsslServer implements the info, sign and authsign endpoints of a cfssl
+// server signing with a local CA.
+type cfsslServer struct {
+	t *testing.T
+	// ca is the CA returned by the info endpoint.
+	ca *CA
+	// signer signs the certificates, with the key of ca unless otherwise set.
+	signer *local.Signer
+	// authKey is the hex encoded key required to sign, if set.
+	authKey string
+	// requests holds the sign requests received.
+	requests []signer.SignRequest
+}
+
+// newCFSSLServer returns a server signing with a new CA, with the "server"
+// and "client" profiles.
+func newCFSSLServer(t *testing.T) *cfsslServer {
+	t.Helper()
+	ca := newTestCA(t, "Remote CA")
+	return &cfsslServer{t: t, ca: ca, signer: newTestSigner(t, ca)}
+}
+
+// newTestCA generates a new CA.
+func newTestCA(t *testing.T, commonName string) *CA {
+	t.Helper()
+	ca := NewCA("", "")
+	if err := ca.Generate(commonName, time.Hour); err != nil {
+		t.Fatalf("failed to generate CA: %v", err)
+	}
+	return ca
+}
+
+// newTestSigner returns a signer signing with the key of ca, with the "server"
+// and "client" profiles.
+func newTestSigner(t *testing.T, ca *CA) *local.Signer {
+	t.Helper()
+	policy := &config.Signing{
+		Default: config.DefaultConfig(),
+		Profiles: map[string]*config.SigningProfile{
+			"server": {Usage: []string{"signing", "key encipherment", "server auth"}, Expiry: 24 * time.Hour},
+			"client": {Usage: []string{"signing", "key encipherment", "client auth"}, Expiry: 48 * time.Hour},
+		},
+	}
+	s, err := local.NewSigner(ca.CAKey, ca.CACert, signer.DefaultSigAlgo(ca.CAKey), policy)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	return s
+}
+
+func (s *cfsslServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req []byte
+	switch r.URL.Path {
+	case "/api/v1/cfssl/info":
+		s.respond(w, map[string]any{"certificate": string(s.ca.CACertBytes)}, nil)
+		return
+	case "/api/v1/cfssl/sign":
+		if s.authKey != "" {
+			s.respond(w, nil, []string{"authentication required"})
+			return
+		}
+		var raw json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+			s.respond(w, nil, []string{err.Error()})
+			return
+		}
+		req = raw
+	case "/api/v1/cfssl/authsign":
+		var aReq auth.AuthenticatedRequest
+		if err := json.NewDecoder(r.Body).Decode(&aReq); err != nil {
+			s.respond(w, nil, []string{err.Error()})
+			return
+		}
+		provider, err := auth.New(s.authKey, nil)
+		if err != nil || !provider.Verify(&aReq) {
+			s.respond(w, nil, []string{"invalid token"})
+			return
+		}
+		req = aReq.Request
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	var signReq signer.SignRequest
+	if err := json.Unmarshal(req, &signReq); err != nil {
+		s.respond(w, nil, []string{err.Error()})
+		return
+	}
+	s.requests = append(s.requests, signReq)
+	cert, err := s.signer.Sign(signReq)
+	if err != nil {
+		s.respond(w, nil, []string{err.Error()})
+		return
+	}
+	s.respond(w, map[string]any{"certificate": string(cert)}, nil)
+}
+
+// respond writes a cfssl API response with result, or errors.
+func (s *cfsslServer) respond(w http.ResponseWriter, result any, errors []string) {
+	resp := api.Response{Success: len(errors) == 0, Result: result}
+	for _, msg := range errors {
+		resp.Errors = append(resp.Errors, api.ResponseMessage{Code: http.StatusBadRequest, Message: msg})
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.t.Errorf("failed to write response: %v", err)
+	}
+}
+
+// start starts an https server serving s, and returns a remote signer sending
+// requests to it with profile and authKey.
+func (s *cfsslServer) start(profile, authKey string) *RemoteSigner {
+	s.t.Helper()
+	srv := httptest.NewTLSServer(s)
+	s.t.Cleanup(srv.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	rs, err := NewRemoteSigner(srv.URL, &tls.Config{RootCAs: roots}, 10*time.Second, profile, "", authKey)
+	if err != nil {
+		s.t.Fatalf("failed to create remote signer: %v", err)
+	}
+	return rs
+}
+
+// signRemote loads the CA of rs, and returns the certificate it issued for
+// commonName.
+func signRemote(t *testing.T, rs *RemoteSigner, commonName string) (*x509.Certificate, *CA, error) {
+	t.Helper()
+	ca := NewCA("", "")
+	if err := ca.LoadFromRemote(rs, nil); err != nil {
+		t.Fatalf("failed to load remote CA: %v", err)
+	}
+	c := NewCert(commonName, 0, []string{"signing", "key encipherment", "server auth"}, "test", "test")
+	if err := c.Generate(ca); err != nil {
+		return nil, ca, err
+	}
+	cert, err := c.Certificate()
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return cert, ca, nil
+}
+
+func TestRemoteSignerSign(t *testing.T) {
+	s := newCFSSLServer(t)
+	cert, ca, err := signRemote(t, s.start("", ""), "server.example.com")
+	if err != nil {
+		t.Fatalf("failed to sign certificate: %v", err)
+	}
+
+	if !ca.IsExternal() || !ca.CACert.Equal(s.ca.CACert) {
+		t.Fatal("remote CA differs from the CA of the server")
+	}
+	if err := cert.CheckSignatureFrom(s.ca.CACert); err != nil {
+		t.Fatalf("certificate is not issued by the remote CA: %v", err)
+	}
+	if len(s.requests) != 1 || !slices.Equal(s.requests[0].Hosts, []string{"server.example.com"}) {
+		t.Fatalf("unexpected sign requests %+v", s.requests)
+	}
+}
+
+func TestRemoteSignerProfile(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		profile     string
+		extKeyUsage []x509.ExtKeyUsage
+		validity    time.Duration
+	}{
+		{name: "server", profile: "server", extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, validity: 24 * time.Hour},
+		{name: "client", profile: "client", extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, validity: 48 * time.Hour},
+		{
+			name:        "default",
+			extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			validity:    config.DefaultConfig().Expiry,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newCFSSLServer(t)
+			cert, _, err := signRemote(t, s.start(tc.profile, ""), "example.com")
+			if err != nil {
+				t.Fatalf("failed to sign certificate: %v", err)
+			}
+			if s.requests[0].Profile != tc.profile {
+				t.Fatalf("requested profile %q, expected %q", s.requests[0].Profile, tc.profile)
+			}
+			if !slices.Equal(cert.ExtKeyUsage, tc.extKeyUsage) {
+				t.Fatalf("certificate has extended key usages %v, expected %v", cert.ExtKeyUsage, tc.extKeyUsage)
+			}
+			if lifetime := cert.NotAfter.Sub(cert.NotBefore); lifetime < tc.validity || lifetime > tc.validity+10*time.Minute {
+				t.Fatalf("certificate is valid for %s, expected the %s of the profile", lifetime, tc.validity)
+			}
+		})
+	}
+}
+
+func TestRemoteSignerAuthKey(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		authKey string
+		err     string
+	}{
+		{name: "valid key", authKey: testAuthKey},
+		{name: "no key", err: "authentication required"},
+		{name: "wrong key", authKey: hex.EncodeToString([]byte("fedcba9876543210")), err: "invalid token"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newCFSSLServer(t)
+			s.authKey = testAuthKey
+			cert, _, err := signRemote(t, s.start("", tc.authKey), "example.com")
+			switch {
+			case tc.err == "" && err != nil:
+				t.Fatalf("failed to sign certificate: %v", err)
+			case tc.err == "" && cert.CheckSignatureFrom(s.ca.CACert) != nil:
+				t.Fatal("certificate is not issued by the remote CA")
+			case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+				t.Fatalf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+
+	if _, err := NewRemoteSigner("https://127.0.0.1", nil, time.Second, "", "", "not hex"); err == nil {
+		t.Fatal("expected an invalid auth key to be rejected")
+	}
+}
+
+func TestRemoteSignerWrongCA(t *testing.T) {
+	// The server signs with another CA than the one returned by its info
+	// endpoint.
+	s := newCFSSLServer(t)
+	s.signer = newTestSigner(t, newTestCA(t, "Other CA"))
+	_, _, err := signRemote(t, s.start("", ""), "example.com")
+	if err == nil || !strings.Contains(err.Error(), "not issued by remote CA") {
+		t.Fatalf("expected certificate not issued by the remote CA to be rejected, got %v", err)
+	}
+}
+
+func TestRemoteSignerWrongTLSCA(t *testing.T) {
+	s := newCFSSLServer(t)
+	srv := httptest.NewTLSServer(s)
+	defer srv.Close()
+
+	// The TLS certificate of the server is not issued by the trusted CA.
+	roots := x509.NewCertPool()
+	roots.AddCert(newTestCA(t, "Other CA").CACert)
+	rs, err := NewRemoteSigner(srv.URL, &tls.Config{RootCAs: roots}, 10*time.Second, "", "", "")
+	if err != nil {
+		t.Fatalf("failed to create remote signer: %v", err)
+	}
+	if err := NewCA("", "").LoadFromRemote(rs, nil); err == nil {
+		t.Fatal("expected server with an untrusted TLS certificate to be rejected")
+	}
+}
+
+func TestLoadFromRemoteChain(t *testing.T) {
+	root := newTestCA(t, "Root CA")
+	intermediate := NewCA("", "")
+	if err := intermediate.GenerateIntermediate(root, "Remote CA", time.Hour); err != nil {
+		t.Fatalf("failed to generate intermediate CA: %v", err)
+	}
+	s := &cfsslServer{t: t, ca: intermediate, signer: newTestSigner(t, intermediate)}
+	rs := s.start("", "")
+
+	ca := NewCA("", "")
+	if err := ca.LoadFromRemote(rs, root.CACertBytes); err != nil {
+		t.Fatalf("failed to load remote CA with its chain: %v", err)
+	}
+	if !ca.IsIntermediate() || string(ca.BundleBytes()) != string(root.CACertBytes) {
+		t.Fatal("remote CA with a chain is not loaded as an intermediate CA")
+	}
+
+	err := NewCA("", "").LoadFromRemote(rs, newTestCA(t, "Other CA").CACertBytes)
+	if err == nil || !strings.Contains(err.Error(), "invalid remote CA chain") {
+		t.Fatalf("expected chain not matching the remote CA to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,234 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package generate
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/sirupsen/logrus"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/cilium/certgen/internal/logging/logfields"
+)
+
+// Action is the action taken by certgen for a certificate.
+type Action string
+
+const (
+	// ActionCreated means that the certificate secret did not exist and has
+	// been created.
+	ActionCreated Action = "created"
+	// ActionRenewed means that the certificate has been regenerated and its
+	// existing secret updated.
+	ActionRenewed Action = "renewed"
+	// ActionUpdated means that the existing certificate has been kept, but its
+	// secret updated (e.g. because the CA bundle changed).
+	ActionUpdated Action = "updated"
+	// ActionKept means that the existing certificate secret has been left
+	// untouched.
+	ActionKept Action = "kept"
+)
+
+// RenewBefore is the window before expiry in which a certificate is renewed,
+// expressed either as an absolute duration or as a percentage of the
+// certificate lifetime.
+type RenewBefore struct {
+	// Duration is the absolute renewal window (if Percentage is zero).
+	Duration time.Duration
+	// Percentage is the renewal window as percentage of the lifetime.
+	Percentage float64
+}
+
+// ParseRenewBefore parses a renewal window, either as a duration (e.g. "720h")
+// or as a percentage of the certificate lifetime (e.g. "33%").
+func ParseRenewBefore(s string) (RenewBefore, error) {
+	if pct, ok := strings.CutSuffix(s, "%"); ok {
+		p, err := strconv.ParseFloat(pct, 64)
+		if err != nil || p <= 0 || p >= 100 {
+			return RenewBefore{}, fmt.Errorf("invalid renewal window %q: percentage must be between 0 and 100", s)
+		}
+		return RenewBefore{Percentage: p}, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return RenewBefore{}, fmt.Errorf("invalid renewal window %q: must be a positive duration or a percentage", s)
+	}
+	return RenewBefore{Duration: d}, nil
+}
+
+// RenewalTime returns the time from which cert is due for renewal.
+func (r RenewBefore) RenewalTime(cert *x509.Certificate) time.Time {
+	if r.Percentage > 0 {
+		lifetime := cert.NotAfter.Sub(cert.NotBefore)
+		return cert.NotAfter.Add(-time.Duration(float64(lifetime) * r.Percentage / 100))
+	}
+	return cert.NotAfter.Add(-r.Duration)
+}
+
+// String implements fmt.Stringer
+func (r RenewBefore) String() string {
+	if r.Percentage > 0 {
+		return strconv.FormatFloat(r.Percentage, 'f', -1, 64) + "%"
+	}
+	return r.Duration.String()
+}
+
+// CheckRenewal reads the existing secret of the certificate and determines
+// whether the certificate needs to be created, renewed or can be kept. The
+// certificate is renewed if it is unparsable, not issued by ca, does not
+// match the requested key, subject, hosts, usages and validity, or is within
+// the renewBefore window. If the certificate is kept, c.CertBytes and c.KeyBytes are
+// populated from the secret, and the secret needs to be updated if its CA
+// bundle or chain is outdated.
+func (c *Cert) CheckRenewal(ctx context.Context, k8sClient *kubernetes.Clientset, ca *CA, renewBefore RenewBefore) (Action, error) {
+	scopedLog := log.WithFields(logrus.Fields{
+		logfields.K8sSecretNamespace: c.Namespace,
+		logfields.K8sSecretName:      c.Name,
+	})
+
+	secret, err := k8sClient.CoreV1().Secrets(c.Namespace).Get(ctx, c.Name, meta_v1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		scopedLog.Info("Secret does not exist, certificate will be created")
+		return ActionCreated, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	action, reason := c.renewalAction(secret.Data, ca, renewBefore)
+	switch action {
+	case ActionRenewed:
+		scopedLog.WithField(logfields.Reason, reason).Info("Certificate will be renewed")
+		return action, nil
+	case ActionUpdated:
+		scopedLog.WithField(logfields.Reason, reason).Info("Certificate will be kept, secret will be updated")
+	}
+
+	c.CA = ca
+	c.CertBytes = leafCertBytes(secret.Data["tls.crt"])
+	c.KeyBytes = secret.Data["tls.key"]
+	return action, nil
+}
+
+// renewalAction determines whether the certificate stored in data needs to be
+// renewed, kept with its secret updated, or kept as is, and the reason why.
+func (c *Cert) renewalAction(data map[string][]byte, ca *CA, renewBefore RenewBefore) (Action, string) {
+	if reason := c.renewalReason(data, ca, renewBefore); reason != "" {
+		return ActionRenewed, reason
+	}
+	if !bytes.Equal(data["ca.crt"], ca.BundleBytes()) {
+		return ActionUpdated, "CA bundle changed"
+	}
+	chain := append(leafCertBytes(data["tls.crt"]), ca.IssuerChainBytes()...)
+	if !bytes.Equal(data["tls.crt"], chain) {
+		return ActionUpdated, "certificate chain changed"
+	}
+	return ActionKept, ""
+}
+
+// renewalReason returns why the certificate stored in data needs to be
+// renewed, or an empty string if it can be kept.
+func (c *Cert) renewalReason(data map[string][]byte, ca *CA, renewBefore RenewBefore) string {
+	if len(data["tls.key"]) == 0 {
+		return "secret has no private key"
+	}
+	if _, err := helpers.ParsePrivateKeyPEM(data["tls.key"]); err != nil {
+		return "unparsable private key"
+	}
+	cert, err := helpers.ParseCertificatePEM(leafCertBytes(data["tls.crt"]))
+	if err != nil {
+		return "missing or unparsable certificate"
+	}
+
+	if ca != nil && ca.CACert != nil && cert.CheckSignatureFrom(ca.CACert) != nil {
+		return "certificate not issued by the current CA"
+	}
+	if ca != nil && ca.CACert == nil && len(ca.CACertBytes) != 0 {
+		// The issuer of the certificates signed through K8s CSRs is only
+		// known to be part of the CA bundle.
+		if verifyChain(cert, ca.bundleCerts(), nil) != nil {
+			return "certificate not issued by the current CA"
+		}
+	}
+	if !keyMatches(cert.PublicKey, c.KeyAlgorithm, c.KeySize) {
+		return "key algorithm or size changed"
+	}
+	if cert.Subject.CommonName != c.CommonName {
+		return "common name changed"
+	}
+	if subjectFromName(cert.Subject) != c.Subject {
+		return "subject changed"
+	}
+	if !slices.Equal(sortedHosts(certHosts(cert)), sortedHosts(c.Hosts)) {
+		return "hosts changed"
+	}
+	if ca != nil && ca.CAKey != nil {
+		// The usages and validity of the certificates issued by a remote
+		// signer or through K8s CSRs are decided by the signer.
+		if usagesChanged(cert, c.Usage) {
+			return "usages changed"
+		}
+		if lifetime := cert.NotAfter.Sub(cert.NotBefore); (lifetime - c.ValidityDuration).Abs() > time.Minute {
+			return "validity duration changed"
+		}
+	}
+	if renewAt := renewBefore.RenewalTime(cert); !time.Now().Before(renewAt) {
+		return "certificate expires on " + cert.NotAfter.Format(time.RFC3339)
+	}
+	return ""
+}
+
+// usagesChanged returns true if the key usages or extended key usages of cert
+// differ from the ones set by cfssl for usages.
+func usagesChanged(cert *x509.Certificate, usages []string) bool {
+	keyUsage, _, _ := (&config.SigningProfile{Usage: usages}).Usages()
+	if cert.KeyUsage != keyUsage {
+		return true
+	}
+	missing, unexpected := diffExtKeyUsages(cert, usages)
+	return len(missing) != 0 || len(unexpected) != 0
+}
+
+// leafCertBytes returns the first certificate of the PEM chain, which is the
+// leaf certificate when issued by an intermediate CA.
+func leafCertBytes(chain []byte) []byte {
+	block, _ := pem.Decode(chain)
+	if block == nil {
+		return nil
+	}
+	return pem.EncodeToMemory(block)
+}
+
+// certHosts returns the SANs of cert in the same format as Cert.Hosts.
+func certHosts(cert *x509.Certificate) []string {
+	hosts := slices.Clone(cert.DNSNames)
+	for _, ip := range cert.IPAddresses {
+		hosts = append(hosts, ip.String())
+	}
+	hosts = append(hosts, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		hosts = append(hosts, uri.String())
+	}
+	return hosts
+}
+
+// sortedHosts returns a sorted and deduplicated copy of hosts.
+func sortedHosts(hosts []string) []string {
+	sorted := slices.Clone(hosts)
+	slices.Sort(sorted)
+	return slices.Compact(sorted)
+}
@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package generate
+
+import (
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRenewBefore(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  RenewBefore
+		err   bool
+	}{
+		{value: "720h", want: RenewBefore{Duration: 720 * time.Hour}},
+		{value: "1h30m", want: RenewBefore{Duration: 90 * time.Minute}},
+		{value: "0s", want: RenewBefore{}},
+		{value: "33%", want: RenewBefore{Percentage: 33}},
+		{value: "12.5%", want: RenewBefore{Percentage: 12.5}},
+		{value: "-1h", err: true},
+		{value: "0%", err: true},
+		{value: "100%", err: true},
+		{value: "-10%", err: true},
+		{value: "ten%", err: true},
+		{value: "30d", err: true},
+		{value: "", err: true},
+	} {
+		t.Run(tc.value, func(t *testing.T) {
+			got, err := ParseRenewBefore(tc.value)
+			switch {
+			case tc.err && err == nil:
+				t.Fatalf("expected %q to be rejected, got %+v", tc.value, got)
+			case !tc.err && err != nil:
+				t.Fatalf("failed to parse %q: %v", tc.value, err)
+			case got != tc.want:
+				t.Fatalf("parsed %q as %+v, expected %+v", tc.value, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRenewBeforeRenewalTime(t *testing.T) {
+	notBefore := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := &x509.Certificate{NotBefore: notBefore, NotAfter: notBefore.Add(100 * time.Hour)}
+	for _, tc := range []struct {
+		name        string
+		renewBefore RenewBefore
+		want        time.Time
+	}{
+		{name: "duration", renewBefore: RenewBefore{Duration: 10 * time.Hour}, want: notBefore.Add(90 * time.Hour)},
+		{name: "zero duration", renewBefore: RenewBefore{}, want: notBefore.Add(100 * time.Hour)},
+		{name: "duration longer than lifetime", renewBefore: RenewBefore{Duration: 200 * time.Hour}, want: notBefore.Add(-100 * time.Hour)},
+		{name: "percentage", renewBefore: RenewBefore{Percentage: 25}, want: notBefore.Add(75 * time.Hour)},
+		{name: "fractional percentage", renewBefore: RenewBefore{Percentage: 12.5}, want: notBefore.Add(87*time.Hour + 30*time.Minute)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.renewBefore.RenewalTime(cert); !got.Equal(tc.want) {
+				t.Fatalf("renewal time is %s, expected %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRenewalReason(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	issued := NewCert("server.example.com", time.Hour, []string{"signing", "key encipherment", "server auth"}, "test", "test").
+		WithHosts([]string{"server.example.com", "*.example.com", "10.0.0.1"}).
+		WithSubject(Subject{Organization: "Cilium"})
+	if err := issued.Generate(ca); err != nil {
+		t.Fatalf("failed to generate certificate: %v", err)
+	}
+	// externalCA only knows the CA certificate, as when signing through a
+	// remote signer or K8s CSRs.
+	externalCA := &CA{CACert: ca.CACert, CACertBytes: ca.CACertBytes}
+
+	for _, tc := range []struct {
+		name        string
+		modify      func(c *Cert)
+		data        map[string][]byte
+		ca          *CA
+		renewBefore RenewBefore
+		reason      string
+	}{
+		{name: "unchanged"},
+		{
+			name:   "hosts in another order",
+			modify: func(c *Cert) { c.Hosts = []string{"10.0.0.1", "*.example.com", "server.example.com", "10.0.0.1"} },
+		},
+		{name: "no private key", data: map[string][]byte{"tls.crt": issued.CertBytes}, reason: "secret has no private key"},
+		{
+			name:   "unparsable private key",
+			data:   map[string][]byte{"tls.crt": issued.CertBytes, "tls.key": []byte("not a key")},
+			reason: "unparsable private key",
+		},
+		{
+			name:   "unparsable certificate",
+			data:   map[string][]byte{"tls.crt": []byte("not a certificate"), "tls.key": issued.KeyBytes},
+			reason: "missing or unparsable certificate",
+		},
+		{name: "other CA", ca: newTestCA(t, "Other CA"), reason: "certificate not issued by the current CA"},
+		{name: "other external CA", ca: &CA{CACertBytes: newTestCA(t, "Other CA").CACertBytes}, reason: "certificate not issued by the current CA"},
+		{name: "key algorithm", modify: func(c *Cert) { c.KeyAlgorithm = KeyAlgorithmRSA }, reason: "key algorithm or size changed"},
+		{
+			name:   "key size",
+			modify: func(c *Cert) { c.KeyAlgorithm, c.KeySize = KeyAlgorithmECDSA, 384 },
+			reason: "key algorithm or size changed",
+		},
+		{name: "common name", modify: func(c *Cert) { c.CommonName = "client.example.com" }, reason: "common name changed"},
+		{name: "subject", modify: func(c *Cert) { c.Subject.OrganizationalUnit = "Hubble" }, reason: "subject changed"},
+		{name: "added host", modify: func(c *Cert) { c.Hosts = append(c.Hosts, "10.0.0.2") }, reason: "hosts changed"},
+		{
+			name:   "removed host",
+			modify: func(c *Cert) { c.Hosts = []string{"server.example.com", "*.example.com"} },
+			reason: "hosts changed",
+		},
+		{
+			name:   "usages",
+			modify: func(c *Cert) { c.Usage = []string{"signing", "key encipherment", "client auth"} },
+			reason: "usages changed",
+		},
+		{
+			name:   "usages with external CA",
+			modify: func(c *Cert) { c.Usage = []string{"signing", "key encipherment", "client auth"} },
+			ca:     externalCA,
+		},
+		{name: "longer validity within tolerance", modify: func(c *Cert) { c.ValidityDuration = time.Hour + 59*time.Second }},
+		{name: "shorter validity within tolerance", modify: func(c *Cert) { c.ValidityDuration = time.Hour - 59*time.Second }},
+		{
+			name:   "longer validity",
+			modify: func(c *Cert) { c.ValidityDuration = time.Hour + 2*time.Minute },
+			reason: "validity duration changed",
+		},
+		{
+			name:   "shorter validity",
+			modify: func(c *Cert) { c.ValidityDuration = time.Hour - 2*time.Minute },
+			reason: "validity duration changed",
+		},
+		{name: "validity with external CA", modify: func(c *Cert) { c.ValidityDuration = 2 * time.Hour }, ca: externalCA},
+		{name: "outside duration window", renewBefore: RenewBefore{Duration: 30 * time.Minute}},
+		{name: "within duration window", renewBefore: RenewBefore{Duration: 2 * time.Hour}, reason: "certificate expires on "},
+		{name: "outside percentage window", renewBefore: RenewBefore{Percentage: 50}},
+		{name: "within percentage window", renewBefore: RenewBefore{Percentage: 99}, reason: "certificate expires on "},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := *issued
+			c.Hosts = append([]string(nil), issued.Hosts...)
+			if tc.modify != nil {
+				tc.modify(&c)
+			}
+			data := tc.data
+			if data == nil {
+				data = map[string][]byte{"tls.crt": issued.CertBytes, "tls.key": issued.KeyBytes}
+			}
+			renewalCA := tc.ca
+			if renewalCA == nil {
+				renewalCA = ca
+			}
+
+			reason := c.renewalReason(data, renewalCA, tc.renewBefore)
+			if tc.reason == "" && reason != "" || !strings.HasPrefix(reason, tc.reason) {
+				t.Fatalf("renewal reason is %q, expected %q", reason, tc.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package generate
+
+import (
+	"crypto/x509"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cfssl/helpers"
+
+	"github.com/cilium/certgen/internal/version"
+)
+
+// Report describes the outcome of a certgen run, to be consumed by tools
+// instead of the logs. It is safe for concurrent use.
+type Report struct {
+	Version    string    `json:"version"`
+	StartTime  time.Time `json:"startTime"`
+	FinishTime time.Time `json:"finishTime"`
+	Success    bool      `json:"success"`
+	// Error is the error which made the run fail, in which case the CAs
+	// and certificates are not reported, as the secrets written during the
+	// run are rolled back.
+	Error string `json:"error,omitempty"`
+
+	CAs          []ReportEntry `json:"cas"`
+	Certificates []ReportEntry `json:"certificates"`
+
+	mu sync.Mutex
+}
+
+// ReportEntry describes a CA or certificate processed during a run, and the
+// action taken for it.
+type ReportEntry struct {
+	Name            string `json:"name"`
+	SecretNamespace string `json:"secretNamespace"`
+	SecretName      string `json:"secretName"`
+	Action          Action `json:"action"`
+	Reason          string `json:"reason,omitempty"`
+
+	CommonName        string     `json:"commonName,omitempty"`
+	SANs              []string   `json:"sans,omitempty"`
+	SerialNumber      string     `json:"serialNumber,omitempty"`
+	Fingerprint       string     `json:"fingerprintSHA256,omitempty"`
+	IssuerFingerprint string     `json:"issuerFingerprintSHA256,omitempty"`
+	NotBefore         *time.Time `json:"notBefore,omitempty"`
+	NotAfter          *time.Time `json:"notAfter,omitempty"`
+}
+
+// ReasonLoadedFromSecret is the reason reported for the CAs loaded from their
+// existing secret.
+const ReasonLoadedFromSecret = "loaded from secret"
+
+// NewReport creates a new report of a run starting now.
+func NewReport() *Report {
+	return &Report{
+		Version:      version.Version,
+		StartTime:    time.Now().UTC(),
+		CAs:          []ReportEntry{},
+		Certificates: []ReportEntry{},
+	}
+}
+
+// AddCA records that action was taken for the CA. It does nothing if r is nil.
+func (r *Report) AddCA(name string, ca *CA, action Action, reason string) {
+	if r == nil {
+		return
+	}
+	entry := NewCAReportEntry(name, ca, action, reason)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.CAs = append(r.CAs, entry)
+}
+
+// AddCert records that action was taken for the certificate. It does nothing
+// if r is nil.
+func (r *Report) AddCert(name string, c *Cert, action Action) {
+	if r == nil {
+		return
+	}
+	entry := NewCertReportEntry(name, c, action)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Certificates = append(r.Certificates, entry)
+}
+
+// Finish records the end of the run, which failed if err is not nil.
+func (r *Report) Finish(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.FinishTime = time.Now().UTC()
+	r.Success = err == nil
+	if err != nil {
+		r.Error = err.Error()
+		r.CAs = []ReportEntry{}
+		r.Certificates = []ReportEntry{}
+	}
+}
+
+// NewCAReportEntry returns the report entry recording that action was taken
+// for the CA.
+func NewCAReportEntry(name string, ca *CA, action Action, reason string) ReportEntry {
+	return newReportEntry(name, ca.SecretNamespace, ca.SecretName, action, reason, ca.CACert, ca.issuerCert())
+}
+
+// NewCertReportEntry returns the report entry recording that action was taken
+// for the certificate.
+func NewCertReportEntry(name string, c *Cert, action Action) ReportEntry {
+	var cert, issuer *x509.Certificate
+	if len(c.CertBytes) != 0 {
+		cert, _ = helpers.ParseCertificatePEM(leafCertBytes(c.CertBytes))
+	}
+	if c.CA != nil {
+		issuer = c.CA.CACert
+	}
+	return newReportEntry(name, c.Namespace, c.Name, action, "", cert, issuer)
+}
+
+// newReportEntry returns the report entry of the secret holding cert, issued
+// by issuer. cert and issuer may be nil if unknown.
+func newReportEntry(name, namespace, secretName string, action Action, reason string, cert, issuer *x509.Certificate) ReportEntry {
+	entry := ReportEntry{
+		Name:            name,
+		SecretNamespace: namespace,
+		SecretName:      secretName,
+		Action:          action,
+		Reason:          reason,
+	}
+	if cert != nil {
+		entry.CommonName = cert.Subject.CommonName
+		entry.SANs = certHosts(cert)
+		entry.SerialNumber = fmt.Sprintf("%x", cert.SerialNumber)
+		entry.Fingerprint = Fingerprint(cert)
+		notBefore, notAfter := cert.NotBefore.UTC(), cert.NotAfter.UTC()
+		entry.NotBefore, entry.NotAfter = &notBefore, &notAfter
+	}
+	if issuer != nil {
+		entry.IssuerFingerprint = Fingerprint(issuer)
+	}
+	return entry
+}
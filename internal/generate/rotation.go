@@ -0,0 +1,223 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package generate
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/cilium/certgen/internal/logging/logfields"
+)
+
+// RotationPhase is the phase of an in-progress CA rotation.
+type RotationPhase string
+
+const (
+	// RotationPhaseNone means that no CA rotation is in progress.
+	RotationPhaseNone RotationPhase = ""
+	// RotationPhasePublish means that the new CA has been generated and is
+	// published in the ca.crt bundle of the leaf secrets, while leaves are
+	// still issued by the old CA.
+	RotationPhasePublish RotationPhase = "publish"
+	// RotationPhaseIssue means that leaves are issued by the new CA, while
+	// the old CA is still published in the ca.crt bundle of the leaf secrets.
+	RotationPhaseIssue RotationPhase = "issue"
+)
+
+const (
+	// rotationPhaseAnnotation is the CA secret annotation storing the phase
+	// of the in-progress CA rotation.
+	rotationPhaseAnnotation = "certgen.cilium.io/ca-rotation-phase"
+	// rotationSinceAnnotation is the CA secret annotation storing when the
+	// current CA rotation phase started.
+	rotationSinceAnnotation = "certgen.cilium.io/ca-rotation-phase-since"
+
+	// nextCACertKey and nextCAKeyKey are the CA secret keys storing the new CA
+	// during the publish phase.
+	nextCACertKey = "ca-next.crt"
+	nextCAKeyKey  = "ca-next.key"
+	// previousCACertKey is the CA secret key storing the old CA certificate
+	// during the issue phase.
+	previousCACertKey = "ca-previous.crt"
+)
+
+// caRotation is the state of a CA rotation, persisted in the CA secret.
+type caRotation struct {
+	phase RotationPhase
+	since time.Time
+
+	nextCertBytes     []byte
+	nextKeyBytes      []byte
+	previousCertBytes []byte
+}
+
+// RotationPhase returns the phase of the in-progress CA rotation, if any.
+func (c *CA) RotationPhase() RotationPhase {
+	return c.rotation.phase
+}
+
+// RotationReason describes the CA rotation phase reached once the rotation
+// moved forward, as reported for the rotated CA.
+func (c *CA) RotationReason() string {
+	if c.rotation.phase == RotationPhaseNone {
+		return "CA rotation completed"
+	}
+	return fmt.Sprintf("CA rotation moved to %s phase", c.rotation.phase)
+}
+
+// NextRotationPhaseAt returns the time from which the in-progress CA rotation
+// moves to its next phase, given the grace period of each phase, or the zero
+// time if no rotation is in progress.
+func (c *CA) NextRotationPhaseAt(gracePeriod time.Duration) time.Time {
+	if c.rotation.phase == RotationPhaseNone {
+		return time.Time{}
+	}
+	return c.rotation.since.Add(gracePeriod)
+}
+
+// BundleBytes returns the PEM bundle of the CA certificates to be trusted by
+// the holders of the leaf certificates. During a rotation, the bundle includes
+// both the old and the new CA. For intermediate CAs, the bundle contains the
+// root CA.
+func (c *CA) BundleBytes() []byte {
+	if c.IsIntermediate() {
+		return c.RootCertBytes
+	}
+
+	var other []byte
+	switch c.rotation.phase {
+	case RotationPhasePublish:
+		other = c.rotation.nextCertBytes
+	case RotationPhaseIssue:
+		other = c.rotation.previousCertBytes
+	}
+	if len(other) == 0 {
+		return c.CACertBytes
+	}
+	bundle := bytes.Join([][]byte{bytes.TrimSpace(c.CACertBytes), bytes.TrimSpace(other)}, []byte("\n"))
+	return append(bundle, '\n')
+}
+
+// AdvanceRotation moves the CA rotation forward. If no rotation is in progress
+// and start is true, a new CA is generated with commonName and
+// validityDuration and published alongside the current one. Once gracePeriod
+// has elapsed, the new CA replaces the current one for issuing leaves, and
+// after another gracePeriod the old CA is dropped. It returns true if the CA
+// changed and needs to be stored.
+func (c *CA) AdvanceRotation(start bool, commonName string, validityDuration, gracePeriod time.Duration) (bool, error) {
+	now := time.Now()
+	scopedLog := log.WithFields(logrus.Fields{
+		logfields.K8sSecretNamespace: c.SecretNamespace,
+		logfields.K8sSecretName:      c.SecretName,
+	})
+
+	switch c.rotation.phase {
+	case RotationPhaseNone:
+		if !start {
+			return false, nil
+		}
+
+		scopedLog.Info("Starting CA rotation, generating new CA")
+		next := &CA{KeyAlgorithm: c.KeyAlgorithm, KeySize: c.KeySize, Subject: c.Subject}
+		if err := next.Generate(commonName, validityDuration); err != nil {
+			return false, fmt.Errorf("failed to generate new CA: %w", err)
+		}
+		c.rotation = caRotation{
+			phase:         RotationPhasePublish,
+			since:         now,
+			nextCertBytes: next.CACertBytes,
+			nextKeyBytes:  next.CAKeyBytes,
+		}
+
+	case RotationPhasePublish:
+		if now.Before(c.rotation.since.Add(gracePeriod)) {
+			scopedLog.WithField(logfields.RotationPhase, c.rotation.phase).Info("CA rotation in progress, grace period not elapsed yet")
+			return false, nil
+		}
+
+		scopedLog.Info("Issuing certificates with the new CA")
+		previous := c.CACertBytes
+		c.CACertBytes = c.rotation.nextCertBytes
+		c.CAKeyBytes = c.rotation.nextKeyBytes
+		if err := c.loadKeyPair(); err != nil {
+			return false, fmt.Errorf("failed to load new CA: %w", err)
+		}
+		c.rotation = caRotation{
+			phase:             RotationPhaseIssue,
+			since:             now,
+			previousCertBytes: previous,
+		}
+
+	case RotationPhaseIssue:
+		if now.Before(c.rotation.since.Add(gracePeriod)) {
+			scopedLog.WithField(logfields.RotationPhase, c.rotation.phase).Info("CA rotation in progress, grace period not elapsed yet")
+			return false, nil
+		}
+
+		scopedLog.Info("Dropping the old CA, CA rotation completed")
+		c.rotation = caRotation{}
+
+	default:
+		return false, fmt.Errorf("unknown CA rotation phase %q", c.rotation.phase)
+	}
+
+	return true, nil
+}
+
+// annotations returns the CA secret annotations persisting the CA
+// rotation state.
+func (r *caRotation) annotations() map[string]string {
+	if r.phase == RotationPhaseNone {
+		return nil
+	}
+	return map[string]string{
+		rotationPhaseAnnotation: string(r.phase),
+		rotationSinceAnnotation: r.since.UTC().Format(time.RFC3339),
+	}
+}
+
+// data returns the CA secret data persisting the CA rotation state.
+func (r *caRotation) data() map[string][]byte {
+	switch r.phase {
+	case RotationPhasePublish:
+		return map[string][]byte{nextCACertKey: r.nextCertBytes, nextCAKeyKey: r.nextKeyBytes}
+	case RotationPhaseIssue:
+		return map[string][]byte{previousCACertKey: r.previousCertBytes}
+	}
+	return nil
+}
+
+// loadRotation restores the CA rotation state from the CA secret annotations
+// and data.
+func loadRotation(annotations map[string]string, data map[string][]byte) (caRotation, error) {
+	phase := RotationPhase(annotations[rotationPhaseAnnotation])
+	if phase == RotationPhaseNone {
+		return caRotation{}, nil
+	}
+
+	since, err := time.Parse(time.RFC3339, annotations[rotationSinceAnnotation])
+	if err != nil {
+		return caRotation{}, fmt.Errorf("invalid %s annotation: %w", rotationSinceAnnotation, err)
+	}
+
+	r := caRotation{phase: phase, since: since}
+	switch phase {
+	case RotationPhasePublish:
+		r.nextCertBytes, r.nextKeyBytes = data[nextCACertKey], data[nextCAKeyKey]
+		if len(r.nextCertBytes) == 0 || len(r.nextKeyBytes) == 0 {
+			return caRotation{}, fmt.Errorf("CA rotation in phase %s but no new CA found", phase)
+		}
+	case RotationPhaseIssue:
+		r.previousCertBytes = data[previousCACertKey]
+		if len(r.previousCertBytes) == 0 {
+			return caRotation{}, fmt.Errorf("CA rotation in phase %s but no previous CA found", phase)
+		}
+	default:
+		return caRotation{}, fmt.Errorf("unknown CA rotation phase %q", phase)
+	}
+	return r, nil
+}
@@ -0,0 +1,211 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package generate
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/helpers"
+)
+
+// bundleCommonNames returns the common names of the certificates in bundle.
+func bundleCommonNames(t *testing.T, bundle []byte) []string {
+	t.Helper()
+	certs, err := helpers.ParseCertificatesPEM(bundle)
+	if err != nil {
+		t.Fatalf("failed to parse CA bundle: %v", err)
+	}
+	var names []string
+	for _, cert := range certs {
+		names = append(names, cert.Subject.CommonName)
+	}
+	return names
+}
+
+// advanceRotation moves the rotation of ca forward, once the grace period
+// elapsed if elapsed is true, and checks whether ca changed.
+func advanceRotation(t *testing.T, ca *CA, start, elapsed, changed bool) {
+	t.Helper()
+	if elapsed {
+		ca.rotation.since = time.Now().Add(-2 * time.Hour)
+	}
+	got, err := ca.AdvanceRotation(start, "New CA", time.Hour, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to advance CA rotation: %v", err)
+	}
+	if got != changed {
+		t.Fatalf("CA rotation in phase %q changed: %t, expected %t", ca.RotationPhase(), got, changed)
+	}
+}
+
+// checkRotationSecret checks that the CA secret persists phase and holds the
+// given rotation keys only.
+func checkRotationSecret(t *testing.T, ca *CA, phase RotationPhase, keys ...string) {
+	t.Helper()
+	secret, err := ca.Secret()
+	if err != nil {
+		t.Fatalf("failed to create CA secret: %v", err)
+	}
+
+	if got := RotationPhase(secret.Annotations[rotationPhaseAnnotation]); got != phase {
+		t.Fatalf("CA secret has rotation phase %q, expected %q", got, phase)
+	}
+	_, hasSince := secret.Annotations[rotationSinceAnnotation]
+	if hasSince != (phase != RotationPhaseNone) {
+		t.Fatalf("CA secret has %s annotation: %t, expected %t", rotationSinceAnnotation, hasSince, phase != RotationPhaseNone)
+	}
+	for _, key := range []string{nextCACertKey, nextCAKeyKey, previousCACertKey} {
+		if _, ok := secret.Data[key]; ok != slices.Contains(keys, key) {
+			t.Fatalf("CA secret has %s: %t, expected %t", key, ok, !ok)
+		}
+	}
+	if !bytes.Equal(secret.Data["ca.crt"], ca.CACertBytes) || !bytes.Equal(secret.Data["ca.key"], ca.CAKeyBytes) {
+		t.Fatal("CA secret does not hold the current CA")
+	}
+
+	// The persisted state is restored when loading the secret.
+	rotation, err := loadRotation(secret.Annotations, secret.Data)
+	if err != nil {
+		t.Fatalf("failed to load CA rotation: %v", err)
+	}
+	if rotation.phase != ca.rotation.phase || !rotation.since.Equal(ca.rotation.since.Truncate(time.Second)) ||
+		!bytes.Equal(rotation.nextCertBytes, ca.rotation.nextCertBytes) ||
+		!bytes.Equal(rotation.nextKeyBytes, ca.rotation.nextKeyBytes) ||
+		!bytes.Equal(rotation.previousCertBytes, ca.rotation.previousCertBytes) {
+		t.Fatalf("loaded CA rotation %+v differs from the persisted one %+v", rotation, ca.rotation)
+	}
+}
+
+func TestAdvanceRotation(t *testing.T) {
+	ca := newTestCA(t, "Old CA")
+	oldCertBytes, oldKeyBytes := ca.CACertBytes, ca.CAKeyBytes
+
+	// No rotation is started unless requested.
+	advanceRotation(t, ca, false, false, false)
+	checkRotationSecret(t, ca, RotationPhaseNone)
+
+	// none -> publish: the new CA is published, the old one still issues.
+	advanceRotation(t, ca, true, false, true)
+	if ca.RotationPhase() != RotationPhasePublish {
+		t.Fatalf("CA rotation is in phase %q, expected %q", ca.RotationPhase(), RotationPhasePublish)
+	}
+	if !bytes.Equal(ca.CACertBytes, oldCertBytes) || !bytes.Equal(ca.CAKeyBytes, oldKeyBytes) {
+		t.Fatal("CA changed in publish phase")
+	}
+	nextCertBytes, nextKeyBytes := ca.rotation.nextCertBytes, ca.rotation.nextKeyBytes
+	checkRotationSecret(t, ca, RotationPhasePublish, nextCACertKey, nextCAKeyKey)
+	if got := bundleCommonNames(t, ca.BundleBytes()); !slices.Equal(got, []string{"Old CA", "New CA"}) {
+		t.Fatalf("CA bundle in publish phase holds %v", got)
+	}
+
+	// The phase only moves forward once the grace period elapsed.
+	advanceRotation(t, ca, true, false, false)
+	if ca.RotationPhase() != RotationPhasePublish {
+		t.Fatalf("CA rotation moved to phase %q before the grace period elapsed", ca.RotationPhase())
+	}
+
+	// publish -> issue: the new CA issues, the old one is still published.
+	advanceRotation(t, ca, false, true, true)
+	if ca.RotationPhase() != RotationPhaseIssue {
+		t.Fatalf("CA rotation is in phase %q, expected %q", ca.RotationPhase(), RotationPhaseIssue)
+	}
+	if !bytes.Equal(ca.CACertBytes, nextCertBytes) || !bytes.Equal(ca.CAKeyBytes, nextKeyBytes) {
+		t.Fatal("new CA does not replace the old one in issue phase")
+	}
+	nextKey, err := helpers.ParsePrivateKeyPEM(nextKeyBytes)
+	if err != nil {
+		t.Fatalf("failed to parse new CA key: %v", err)
+	}
+	if ca.CACert.Subject.CommonName != "New CA" || !equalKeys(ca.CAKey, nextKey) {
+		t.Fatal("new CA key pair is not loaded in issue phase")
+	}
+	if !bytes.Equal(ca.rotation.previousCertBytes, oldCertBytes) {
+		t.Fatal("old CA is not kept in issue phase")
+	}
+	checkRotationSecret(t, ca, RotationPhaseIssue, previousCACertKey)
+	if got := bundleCommonNames(t, ca.BundleBytes()); !slices.Equal(got, []string{"New CA", "Old CA"}) {
+		t.Fatalf("CA bundle in issue phase holds %v", got)
+	}
+
+	advanceRotation(t, ca, false, false, false)
+	if ca.RotationPhase() != RotationPhaseIssue {
+		t.Fatalf("CA rotation moved to phase %q before the grace period elapsed", ca.RotationPhase())
+	}
+
+	// issue -> none: the old CA is dropped.
+	advanceRotation(t, ca, false, true, true)
+	if ca.RotationPhase() != RotationPhaseNone {
+		t.Fatalf("CA rotation is in phase %q, expected it to be completed", ca.RotationPhase())
+	}
+	if !bytes.Equal(ca.CACertBytes, nextCertBytes) {
+		t.Fatal("new CA is not kept once the rotation completed")
+	}
+	checkRotationSecret(t, ca, RotationPhaseNone)
+	if !bytes.Equal(ca.BundleBytes(), ca.CACertBytes) {
+		t.Fatal("CA bundle does not hold the new CA only once the rotation completed")
+	}
+}
+
+func TestBundleBytesIntermediate(t *testing.T) {
+	root := newTestCA(t, "Root CA")
+	ca := NewCA("", "")
+	if err := ca.GenerateIntermediate(root, "Intermediate CA", time.Hour); err != nil {
+		t.Fatalf("failed to generate intermediate CA: %v", err)
+	}
+
+	for _, phase := range []RotationPhase{RotationPhaseNone, RotationPhasePublish, RotationPhaseIssue} {
+		ca.rotation = caRotation{
+			phase:             phase,
+			nextCertBytes:     newTestCA(t, "New CA").CACertBytes,
+			previousCertBytes: newTestCA(t, "Old CA").CACertBytes,
+		}
+		if !bytes.Equal(ca.BundleBytes(), root.CACertBytes) {
+			t.Fatalf("CA bundle of intermediate CA in phase %q is not the root CA", phase)
+		}
+	}
+}
+
+func TestLoadRotationInvalid(t *testing.T) {
+	since := time.Now().UTC().Format(time.RFC3339)
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		data        map[string][]byte
+		err         string
+	}{
+		{
+			name:        "unknown phase",
+			annotations: map[string]string{rotationPhaseAnnotation: "unknown", rotationSinceAnnotation: since},
+			err:         "unknown CA rotation phase",
+		},
+		{
+			name:        "missing since",
+			annotations: map[string]string{rotationPhaseAnnotation: string(RotationPhaseIssue)},
+			data:        map[string][]byte{previousCACertKey: []byte("cert")},
+			err:         "invalid " + rotationSinceAnnotation,
+		},
+		{
+			name:        "missing next CA key",
+			annotations: map[string]string{rotationPhaseAnnotation: string(RotationPhasePublish), rotationSinceAnnotation: since},
+			data:        map[string][]byte{nextCACertKey: []byte("cert")},
+			err:         "no new CA found",
+		},
+		{
+			name:        "missing previous CA",
+			annotations: map[string]string{rotationPhaseAnnotation: string(RotationPhaseIssue), rotationSinceAnnotation: since},
+			err:         "no previous CA found",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadRotation(tc.annotations, tc.data)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package generate
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/signer"
+	"github.com/cloudflare/cfssl/signer/local"
+)
+
+// SigningPolicy restricts the CSRs signed with a CA on behalf of other
+// components.
+type SigningPolicy struct {
+	// AllowedUsages are the key usages which may be requested.
+	AllowedUsages []string
+	// AllowedDNSNames are the DNS names which may be requested as SAN or
+	// common name. A pattern starting with "*." matches any subdomain of
+	// the rest of the pattern, including wildcard names.
+	AllowedDNSNames []string
+	// AllowedIPRanges are the networks the IP SANs must belong to.
+	AllowedIPRanges []*net.IPNet
+	// MaxDuration is the maximum validity of the certificates, which is also
+	// used if the request does not set any.
+	MaxDuration time.Duration
+}
+
+// ParseCSR parses a PEM encoded CSR and checks its signature.
+func ParseCSR(csrPEM []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("request is not a PEM encoded CERTIFICATE REQUEST")
+	}
+	req, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse request: %w", err)
+	}
+	if err := req.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid request signature: %w", err)
+	}
+	return req, nil
+}
+
+// Check returns an error describing why req, requesting the given key usages,
+// violates the policy.
+func (p SigningPolicy) Check(req *x509.CertificateRequest, usages []string) error {
+	if len(usages) == 0 {
+		return errors.New("no usage requested")
+	}
+	for _, usage := range usages {
+		if !slices.Contains(p.AllowedUsages, usage) {
+			return fmt.Errorf("usage %q is not allowed", usage)
+		}
+	}
+	if cn := req.Subject.CommonName; cn != "" && !p.dnsNameAllowed(cn) {
+		return fmt.Errorf("common name %q is not allowed", cn)
+	}
+	for _, name := range req.DNSNames {
+		if !p.dnsNameAllowed(name) {
+			return fmt.Errorf("DNS name %q is not allowed", name)
+		}
+	}
+	for _, ip := range req.IPAddresses {
+		if !slices.ContainsFunc(p.AllowedIPRanges, func(n *net.IPNet) bool { return n.Contains(ip) }) {
+			return fmt.Errorf("IP address %s is not allowed", ip)
+		}
+	}
+	if len(req.EmailAddresses) != 0 {
+		return errors.New("email address SANs are not allowed")
+	}
+	if len(req.URIs) != 0 {
+		return errors.New("URI SANs are not allowed")
+	}
+	for _, ext := range req.Extensions {
+		// BasicConstraints, as CA certificates are never issued.
+		if ext.Id.Equal([]int{2, 5, 29, 19}) {
+			return errors.New("CA certificates are not allowed")
+		}
+	}
+	return nil
+}
+
+// Duration returns the validity of a certificate requesting the given
+// duration, capped to the maximum duration.
+func (p SigningPolicy) Duration(requested time.Duration) time.Duration {
+	if requested <= 0 {
+		return p.MaxDuration
+	}
+	return min(requested, p.MaxDuration)
+}
+
+// dnsNameAllowed returns true if name matches one of the allowed DNS names.
+func (p SigningPolicy) dnsNameAllowed(name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range p.AllowedDNSNames {
+		pattern = strings.ToLower(pattern)
+		if domain, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(name, "."+domain) && len(name) > len(domain)+1 {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateDNSNamePattern returns an error if pattern is not a DNS name, or a
+// DNS name prefixed with "*." to match its subdomains.
+func ValidateDNSNamePattern(pattern string) error {
+	name := strings.TrimPrefix(pattern, "*.")
+	switch {
+	case name == "":
+		return fmt.Errorf("invalid DNS name pattern %q: empty name", pattern)
+	case strings.Contains(name, "*"):
+		return fmt.Errorf("invalid DNS name pattern %q: wildcards are only allowed as leading \"*.\"", pattern)
+	case slices.Contains(strings.Split(name, "."), ""):
+		return fmt.Errorf("invalid DNS name pattern %q: empty label", pattern)
+	}
+	return nil
+}
+
+// SignCSR signs the PEM encoded CSR with the key of c, for the given key
+// usages and validity, and returns the certificate followed by the
+// intermediate CA certificates of c, if any. Only the subject, public key and
+// SANs of the CSR are used.
+func (c *CA) SignCSR(csrPEM []byte, usages []string, duration time.Duration) ([]byte, error) {
+	if c.CAKey == nil {
+		return nil, errors.New("CA has no key")
+	}
+	policy := &config.Signing{
+		Default: &config.SigningProfile{
+			Usage:  usages,
+			Expiry: duration,
+			CSRWhitelist: &config.CSRWhitelist{
+				Subject:            true,
+				PublicKeyAlgorithm: true,
+				PublicKey:          true,
+				SignatureAlgorithm: true,
+				DNSNames:           true,
+				IPAddresses:        true,
+			},
+		},
+	}
+	s, err := local.NewSigner(c.CAKey, c.CACert, signer.DefaultSigAlgo(c.CAKey), policy)
+	if err != nil {
+		return nil, err
+	}
+	certBytes, err := s.Sign(signer.SignRequest{Request: string(csrPEM)})
+	if err != nil {
+		return nil, err
+	}
+	return append(certBytes, c.IssuerChainBytes()...), nil
+}
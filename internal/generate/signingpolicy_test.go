@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package generate
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigningPolicyCheck(t *testing.T) {
+	_, v4Range, _ := net.ParseCIDR("10.0.0.0/8")
+	_, v6Range, _ := net.ParseCIDR("fd00::/8")
+	policy := SigningPolicy{
+		AllowedUsages:   []string{"signing", "key encipherment", "server auth"},
+		AllowedDNSNames: []string{"*.cilium.io", "Exact.Example.com"},
+		AllowedIPRanges: []*net.IPNet{v4Range, v6Range},
+		MaxDuration:     time.Hour,
+	}
+	usages := []string{"signing", "server auth"}
+	spiffe, _ := url.Parse("spiffe://cilium.io/hubble")
+
+	for _, tc := range []struct {
+		name     string
+		req      x509.CertificateRequest
+		usages   []string
+		patterns []string
+		err      string
+	}{
+		{name: "subdomain", req: x509.CertificateRequest{DNSNames: []string{"hubble.cilium.io"}}},
+		{name: "nested subdomain", req: x509.CertificateRequest{DNSNames: []string{"a.b.hubble.cilium.io"}}},
+		{name: "wildcard name", req: x509.CertificateRequest{DNSNames: []string{"*.cilium.io"}}},
+		{name: "nested wildcard name", req: x509.CertificateRequest{DNSNames: []string{"*.hubble.cilium.io"}}},
+		{name: "wildcard domain", req: x509.CertificateRequest{DNSNames: []string{"cilium.io"}}, err: `DNS name "cilium.io" is not allowed`},
+		{
+			name: "wildcard suffix without dot",
+			req:  x509.CertificateRequest{DNSNames: []string{"attackercilium.io"}},
+			err:  `DNS name "attackercilium.io" is not allowed`,
+		},
+		{name: "wildcard empty label", req: x509.CertificateRequest{DNSNames: []string{".cilium.io"}}, err: `DNS name ".cilium.io" is not allowed`},
+		{name: "exact name", req: x509.CertificateRequest{DNSNames: []string{"exact.example.com"}}},
+		{
+			name: "subdomain of exact name",
+			req:  x509.CertificateRequest{DNSNames: []string{"sub.exact.example.com"}},
+			err:  `DNS name "sub.exact.example.com" is not allowed`,
+		},
+		{name: "case", req: x509.CertificateRequest{DNSNames: []string{"Hubble.CILIUM.io", "EXACT.example.COM"}}},
+		{
+			name: "one disallowed name",
+			req:  x509.CertificateRequest{DNSNames: []string{"hubble.cilium.io", "example.com"}},
+			err:  `DNS name "example.com" is not allowed`,
+		},
+		{
+			name:     "bare wildcard pattern",
+			req:      x509.CertificateRequest{DNSNames: []string{"example.com"}},
+			patterns: []string{"*"},
+			err:      `DNS name "example.com" is not allowed`,
+		},
+		{
+			name:     "wildcard pattern without dot",
+			req:      x509.CertificateRequest{DNSNames: []string{"attackerexample.com"}},
+			patterns: []string{"*example.com"},
+			err:      `DNS name "attackerexample.com" is not allowed`,
+		},
+		{name: "common name", req: x509.CertificateRequest{Subject: pkix.Name{CommonName: "hubble.cilium.io"}}},
+		{
+			name: "disallowed common name",
+			req: x509.CertificateRequest{
+				Subject:  pkix.Name{CommonName: "example.com"},
+				DNSNames: []string{"hubble.cilium.io"},
+			},
+			err: `common name "example.com" is not allowed`,
+		},
+		{name: "IPv4 in range", req: x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("10.1.2.3")}}},
+		{name: "IPv6 in range", req: x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("fd00::1")}}},
+		{
+			name: "IPv4 out of range",
+			req:  x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("192.168.1.1")}},
+			err:  "IP address 192.168.1.1 is not allowed",
+		},
+		{
+			name: "IPv6 out of range",
+			req:  x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("2001:db8::1")}},
+			err:  "IP address 2001:db8::1 is not allowed",
+		},
+		{name: "email SAN", req: x509.CertificateRequest{EmailAddresses: []string{"admin@cilium.io"}}, err: "email address SANs are not allowed"},
+		{name: "URI SAN", req: x509.CertificateRequest{URIs: []*url.URL{spiffe}}, err: "URI SANs are not allowed"},
+		{
+			name: "basic constraints",
+			req: x509.CertificateRequest{
+				DNSNames:   []string{"hubble.cilium.io"},
+				Extensions: []pkix.Extension{{Id: asn1.ObjectIdentifier{2, 5, 29, 19}, Value: []byte{0x30, 0x03, 0x01, 0x01, 0xff}}},
+			},
+			err: "CA certificates are not allowed",
+		},
+		{name: "disallowed usage", usages: []string{"signing", "client auth"}, err: `usage "client auth" is not allowed`},
+		{name: "no usage", usages: []string{}, err: "no usage requested"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := policy
+			if tc.patterns != nil {
+				p.AllowedDNSNames = tc.patterns
+			}
+			requested := usages
+			if tc.usages != nil {
+				requested = tc.usages
+			}
+
+			err := p.Check(&tc.req, requested)
+			switch {
+			case tc.err == "" && err != nil:
+				t.Fatalf("expected request to be allowed, got %v", err)
+			case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+				t.Fatalf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestValidateDNSNamePattern(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		valid   bool
+	}{
+		{pattern: "cilium.io", valid: true},
+		{pattern: "*.cilium.io", valid: true},
+		{pattern: "*.hubble.cilium.io", valid: true},
+		{pattern: "localhost", valid: true},
+		{pattern: ""},
+		{pattern: "*"},
+		{pattern: "*."},
+		{pattern: "*cilium.io"},
+		{pattern: "*.*.cilium.io"},
+		{pattern: "hubble.*.cilium.io"},
+		{pattern: "hubble*.cilium.io"},
+		{pattern: ".cilium.io"},
+		{pattern: "*..cilium.io"},
+		{pattern: "cilium.io."},
+	} {
+		t.Run(tc.pattern, func(t *testing.T) {
+			err := ValidateDNSNamePattern(tc.pattern)
+			if tc.valid && err != nil {
+				t.Fatalf("expected pattern %q to be valid, got %v", tc.pattern, err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatalf("expected pattern %q to be rejected", tc.pattern)
+			}
+		})
+	}
+}
+
+func TestSigningPolicyDuration(t *testing.T) {
+	policy := SigningPolicy{MaxDuration: time.Hour}
+	for requested, want := range map[time.Duration]time.Duration{
+		0:                time.Hour,
+		-time.Minute:     time.Hour,
+		30 * time.Minute: 30 * time.Minute,
+		2 * time.Hour:    time.Hour,
+	} {
+		if got := policy.Duration(requested); got != want {
+			t.Fatalf("duration requested %s is %s, expected %s", requested, got, want)
+		}
+	}
+}
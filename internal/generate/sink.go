@@ -0,0 +1,238 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package generate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/csaupgrade"
+	"sigs.k8s.io/yaml"
+
+	"github.com/cilium/certgen/internal/logging/logfields"
+)
+
+// Sink stores the secrets holding the generated CAs and certificates.
+type Sink interface {
+	// Store creates or updates secret. If force is false and the secret
+	// already exists, an IsAlreadyExists error is returned instead.
+	Store(ctx context.Context, secret *v1.Secret, force bool) error
+}
+
+// secretsResource is the resource used in the errors returned by sinks.
+var secretsResource = schema.GroupResource{Resource: "secrets"}
+
+// FieldManager is the field manager used to create and apply the K8s Secrets.
+const FieldManager = "cilium-certgen"
+
+// updateManagers are the field managers of the create and update requests made
+// by certgen: FieldManager, and the default field manager derived from the
+// user agent, used before the secrets were applied.
+var updateManagers = sets.New(FieldManager, strings.SplitN(rest.DefaultKubernetesUserAgent(), "/", 2)[0])
+
+// SecretSink stores secrets as K8s Secrets, using server-side apply so that
+// only the data keys, labels and annotations set by certgen are owned by it,
+// and the fields set by others are left untouched. Secrets which are not
+// forced are created instead, so that concurrent runs cannot both create them.
+type SecretSink struct {
+	k8sClient      *kubernetes.Clientset
+	forceConflicts bool
+}
+
+// NewSecretSink creates a new sink storing secrets in the cluster of k8sClient.
+func NewSecretSink(k8sClient *kubernetes.Clientset) *SecretSink {
+	return &SecretSink{k8sClient: k8sClient}
+}
+
+// WithForceConflicts modifies to take ownership of the fields managed by other
+// field managers, instead of failing with a conflict error
+func (s *SecretSink) WithForceConflicts(force bool) *SecretSink {
+	s.forceConflicts = force
+	return s
+}
+
+// Store implements Sink
+func (s *SecretSink) Store(ctx context.Context, secret *v1.Secret, force bool) error {
+	scopedLog := logger(ctx).WithFields(logrus.Fields{
+		logfields.K8sSecretNamespace: secret.Namespace,
+		logfields.K8sSecretName:      secret.Name,
+	})
+
+	k8sSecrets := s.k8sClient.CoreV1().Secrets(secret.Namespace)
+	if !force {
+		scopedLog.Info("Creating K8s Secret")
+		_, err := k8sSecrets.Create(ctx, secret, meta_v1.CreateOptions{FieldManager: FieldManager})
+		if k8sErrors.IsAlreadyExists(err) {
+			scopedLog.Warn("Secret already exists")
+		}
+		return err
+	}
+
+	if err := s.upgradeManagedFields(ctx, secret.Namespace, secret.Name); err != nil {
+		return fmt.Errorf("failed to upgrade managed fields of secret %s/%s: %w", secret.Namespace, secret.Name, err)
+	}
+
+	scopedLog.Info("Applying K8s Secret")
+	apply := corev1ac.Secret(secret.Name, secret.Namespace).
+		WithLabels(secret.Labels).
+		WithAnnotations(secret.Annotations).
+		WithData(secret.Data)
+	if secret.Type != "" {
+		apply.WithType(secret.Type)
+	}
+	_, err := k8sSecrets.Apply(ctx, apply, meta_v1.ApplyOptions{
+		FieldManager: FieldManager,
+		Force:        s.forceConflicts,
+	})
+	if k8sErrors.IsConflict(err) {
+		return fmt.Errorf("secret %s/%s has fields managed by another field manager, "+
+			"conflicts must be forced (--force-conflicts) to take ownership of them: %w", secret.Namespace, secret.Name, err)
+	}
+	return err
+}
+
+// upgradeManagedFields moves the ownership of the fields written to the secret
+// by the create and update requests of certgen to the field manager applying
+// it, so that applying the secret does not conflict with certgen itself, e.g.
+// when renewing a certificate created without server-side apply.
+func (s *SecretSink) upgradeManagedFields(ctx context.Context, namespace, name string) error {
+	k8sSecrets := s.k8sClient.CoreV1().Secrets(namespace)
+	existing, err := k8sSecrets.Get(ctx, name, meta_v1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	patch, err := csaupgrade.UpgradeManagedFieldsPatch(existing, updateManagers, FieldManager)
+	if err != nil || patch == nil {
+		return err
+	}
+	_, err = k8sSecrets.Patch(ctx, name, types.JSONPatchType, patch, meta_v1.PatchOptions{})
+	return err
+}
+
+// FileSink stores secrets as PEM files, in a <namespace>/<name> directory per
+// secret with one file per key, readable by the owner only.
+type FileSink struct {
+	dir string
+}
+
+// NewFileSink creates a new sink storing secrets as files under dir.
+func NewFileSink(dir string) *FileSink {
+	return &FileSink{dir: dir}
+}
+
+// Store implements Sink
+func (s *FileSink) Store(ctx context.Context, secret *v1.Secret, force bool) error {
+	dir := filepath.Join(s.dir, secret.Namespace, secret.Name)
+	logger(ctx).WithField(logfields.Path, dir).Info("Writing secret to directory")
+
+	existing, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(existing) != 0 && !force {
+		return k8sErrors.NewAlreadyExists(secretsResource, secret.Name)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	for key, value := range secret.Data {
+		if err := writeFileAtomic(filepath.Join(dir, key), value); err != nil {
+			return fmt.Errorf("failed to write %s: %w", key, err)
+		}
+	}
+	// Remove the keys which are not part of the secret anymore.
+	for _, entry := range existing {
+		if _, ok := secret.Data[entry.Name()]; !ok && entry.Type().IsRegular() {
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to path with owner-only permissions, replacing
+// the existing file (if any) atomically.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	// CreateTemp already creates the file with 0600 permissions, but be
+	// explicit as the file contains private keys.
+	if err := f.Chmod(0o600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// ManifestSink writes secrets as multi-document YAML K8s manifests.
+type ManifestSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewManifestSink creates a new sink writing secret manifests to w.
+func NewManifestSink(w io.Writer) *ManifestSink {
+	return &ManifestSink{w: w}
+}
+
+// Store implements Sink. The manifests are always written, as the sink cannot
+// know whether the secret already exists.
+func (s *ManifestSink) Store(_ context.Context, secret *v1.Secret, _ bool) error {
+	manifest := secret.DeepCopy()
+	manifest.TypeMeta = meta_v1.TypeMeta{APIVersion: "v1", Kind: "Secret"}
+	out, err := yaml.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to marshal secret %s/%s: %w", secret.Namespace, secret.Name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = fmt.Fprintf(s.w, "---\n%s", out)
+	return err
+}
+
+// MultiSink stores secrets in multiple sinks, in order. Storing stops at the
+// first sink returning an error.
+type MultiSink []Sink
+
+// Store implements Sink
+func (m MultiSink) Store(ctx context.Context, secret *v1.Secret, force bool) error {
+	for _, sink := range m {
+		if err := sink.Store(ctx, secret, force); err != nil {
+			return err
+		}
+	}
+	return nil
+}
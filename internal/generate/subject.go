@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package generate
+
+import (
+	"crypto/x509/pkix"
+
+	"github.com/cloudflare/cfssl/csr"
+)
+
+// Subject contains the x509 subject fields of a certificate besides the
+// common name. Empty fields are omitted from the certificate.
+type Subject struct {
+	Country            string
+	State              string
+	Locality           string
+	Organization       string
+	OrganizationalUnit string
+	SerialNumber       string
+}
+
+// names returns the subject as list of CSR names
+func (s Subject) names() []csr.Name {
+	name := csr.Name{C: s.Country, ST: s.State, L: s.Locality, O: s.Organization, OU: s.OrganizationalUnit}
+	if csr.IsNameEmpty(name) {
+		return nil
+	}
+	return []csr.Name{name}
+}
+
+// subjectFromName returns the subject fields of an x509 name
+func subjectFromName(name pkix.Name) Subject {
+	first := func(values []string) string {
+		if len(values) == 0 {
+			return ""
+		}
+		return values[0]
+	}
+	return Subject{
+		Country:            first(name.Country),
+		State:              first(name.Province),
+		Locality:           first(name.Locality),
+		Organization:       first(name.Organization),
+		OrganizationalUnit: first(name.OrganizationalUnit),
+		SerialNumber:       name.SerialNumber,
+	}
+}
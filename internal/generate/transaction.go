@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package generate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/cilium/certgen/internal/logging/logfields"
+)
+
+// Transaction is a sink storing secrets as K8s Secrets, which snapshots every
+// secret before writing it, so that all the writes of a run can be rolled back
+// if one of them fails. It is safe for concurrent use.
+type Transaction struct {
+	k8sClient      *kubernetes.Clientset
+	sink           *SecretSink
+	requestTimeout time.Duration
+
+	mu        sync.Mutex
+	snapshots []snapshot
+}
+
+// snapshot is the state of a secret before it was written by a transaction.
+type snapshot struct {
+	namespace string
+	name      string
+	// previous is the secret before the write, or nil if it did not exist.
+	previous *v1.Secret
+}
+
+// RollbackResult is the outcome of a transaction rollback.
+type RollbackResult struct {
+	// Restored is the number of secrets restored to their previous version.
+	Restored int
+	// Deleted is the number of secrets created by the transaction, and
+	// deleted.
+	Deleted int
+	// Err describes the secrets which could not be rolled back, if any.
+	Err error
+}
+
+// String implements fmt.Stringer
+func (r RollbackResult) String() string {
+	s := fmt.Sprintf("%d secrets restored, %d created secrets deleted", r.Restored, r.Deleted)
+	if r.Err != nil {
+		s += fmt.Sprintf(", rollback failed: %s", r.Err)
+	}
+	return s
+}
+
+// NewTransaction creates a new transaction storing secrets with sink.
+// requestTimeout bounds each request made during the rollback.
+func NewTransaction(sink *SecretSink, requestTimeout time.Duration) *Transaction {
+	return &Transaction{
+		k8sClient:      sink.k8sClient,
+		sink:           sink,
+		requestTimeout: requestTimeout,
+	}
+}
+
+// Store implements Sink
+func (t *Transaction) Store(ctx context.Context, secret *v1.Secret, force bool) error {
+	previous, err := t.k8sClient.CoreV1().Secrets(secret.Namespace).Get(ctx, secret.Name, meta_v1.GetOptions{})
+	switch {
+	case k8sErrors.IsNotFound(err):
+		previous = nil
+	case err != nil:
+		return fmt.Errorf("failed to snapshot secret %s/%s: %w", secret.Namespace, secret.Name, err)
+	}
+
+	if err := t.sink.Store(ctx, secret, force); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.snapshots = append(t.snapshots, snapshot{
+		namespace: secret.Namespace,
+		name:      secret.Name,
+		previous:  previous,
+	})
+	return nil
+}
+
+// Writes returns the number of secrets written by the transaction.
+func (t *Transaction) Writes() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.snapshots)
+}
+
+// Rollback restores all the secrets written by the transaction to their
+// previous version, in reverse order, and deletes the ones it created. It
+// attempts to roll back every secret even if some of them fail.
+func (t *Transaction) Rollback() RollbackResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var result RollbackResult
+	var errs []error
+	for i := len(t.snapshots) - 1; i >= 0; i-- {
+		s := t.snapshots[i]
+		scopedLog := log.WithFields(logrus.Fields{
+			logfields.K8sSecretNamespace: s.namespace,
+			logfields.K8sSecretName:      s.name,
+		})
+
+		if err := t.rollback(s); err != nil {
+			scopedLog.WithError(err).Error("Failed to roll back K8s Secret")
+			errs = append(errs, fmt.Errorf("%s/%s: %w", s.namespace, s.name, err))
+			continue
+		}
+		if s.previous == nil {
+			scopedLog.Info("Deleted K8s Secret created during the failed run")
+			result.Deleted++
+		} else {
+			scopedLog.Info("Restored previous version of K8s Secret")
+			result.Restored++
+		}
+	}
+	t.snapshots = nil
+	result.Err = errors.Join(errs...)
+	return result
+}
+
+// rollback restores the secret described by s to its previous version.
+func (t *Transaction) rollback(s snapshot) error {
+	ctx, cancel := context.WithTimeout(context.Background(), t.requestTimeout)
+	defer cancel()
+
+	k8sSecrets := t.k8sClient.CoreV1().Secrets(s.namespace)
+	if s.previous == nil {
+		err := k8sSecrets.Delete(ctx, s.name, meta_v1.DeleteOptions{})
+		if k8sErrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	previous := s.previous.DeepCopy()
+	previous.ManagedFields = nil
+	current, err := k8sSecrets.Get(ctx, s.name, meta_v1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		previous.ResourceVersion = ""
+		previous.UID = ""
+		_, err = k8sSecrets.Create(ctx, previous, meta_v1.CreateOptions{FieldManager: FieldManager})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	previous.ResourceVersion = current.ResourceVersion
+	previous.UID = current.UID
+	_, err = k8sSecrets.Update(ctx, previous, meta_v1.UpdateOptions{FieldManager: FieldManager})
+	return err
+}
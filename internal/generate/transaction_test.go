@@ -0,0 +1,348 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package generate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// secretServer implements the secrets endpoints of the K8s API, in the
+// "test" namespace.
+type secretServer struct {
+	t *testing.T
+
+	mu      sync.Mutex
+	secrets map[string]*v1.Secret
+	version int
+	// requests holds the write requests received, in order.
+	requests []secretRequest
+	// failing holds the names of the secrets whose writes fail.
+	failing []string
+}
+
+// secretRequest is a write request received by secretServer.
+type secretRequest struct {
+	method string
+	name   string
+	// secret is the body of the create and update requests.
+	secret *v1.Secret
+}
+
+// newSecretServer starts a server holding secrets, and returns it with a
+// client sending requests to it.
+func newSecretServer(t *testing.T, secrets ...*v1.Secret) (*secretServer, *kubernetes.Clientset) {
+	t.Helper()
+	s := &secretServer{t: t, secrets: make(map[string]*v1.Secret)}
+	for _, secret := range secrets {
+		s.put(secret.DeepCopy(), true)
+	}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	k8sClient, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL, QPS: 1000, Burst: 1000})
+	if err != nil {
+		t.Fatalf("failed to create K8s client: %v", err)
+	}
+	return s, k8sClient
+}
+
+// put stores secret with a new resource version, and a new UID if created.
+func (s *secretServer) put(secret *v1.Secret, created bool) *v1.Secret {
+	s.version++
+	secret.TypeMeta = meta_v1.TypeMeta{Kind: "Secret", APIVersion: "v1"}
+	secret.Namespace = "test"
+	secret.ResourceVersion = strconv.Itoa(s.version)
+	if created {
+		secret.UID = types.UID(fmt.Sprintf("uid-%d", s.version))
+	}
+	s.secrets[secret.Name] = secret
+	return secret
+}
+
+// get returns the stored secret name, or nil.
+func (s *secretServer) get(name string) *v1.Secret {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.secrets[name].DeepCopy()
+}
+
+// delete deletes the stored secret name.
+func (s *secretServer) delete(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.secrets, name)
+}
+
+func (s *secretServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path, ok := strings.CutPrefix(r.URL.Path, "/api/v1/namespaces/test/secrets")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	name := strings.TrimPrefix(path, "/")
+	gr := schema.GroupResource{Resource: "secrets"}
+
+	var body *v1.Secret
+	if r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch {
+		body = &v1.Secret{}
+		if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+			s.respond(w, k8sErrors.NewBadRequest(err.Error()))
+			return
+		}
+		if name == "" {
+			name = body.Name
+		}
+	}
+	if r.Method != http.MethodGet {
+		s.requests = append(s.requests, secretRequest{method: r.Method, name: name, secret: body.DeepCopy()})
+		if slices.Contains(s.failing, name) {
+			s.respond(w, k8sErrors.NewInternalError(fmt.Errorf("failing secret %s", name)))
+			return
+		}
+	}
+
+	existing := s.secrets[name]
+	switch r.Method {
+	case http.MethodGet:
+		if existing == nil {
+			s.respond(w, k8sErrors.NewNotFound(gr, name))
+			return
+		}
+		s.respond(w, existing)
+
+	case http.MethodPost:
+		switch {
+		case existing != nil:
+			s.respond(w, k8sErrors.NewAlreadyExists(gr, name))
+		case body.ResourceVersion != "":
+			s.respond(w, k8sErrors.NewBadRequest("resourceVersion should not be set on objects to be created"))
+		default:
+			s.respond(w, s.put(body, true))
+		}
+
+	case http.MethodPut:
+		switch {
+		case existing == nil:
+			s.respond(w, k8sErrors.NewNotFound(gr, name))
+		case body.UID != "" && body.UID != existing.UID:
+			s.respond(w, k8sErrors.NewConflict(gr, name, fmt.Errorf("precondition failed: UID %s", body.UID)))
+		case body.ResourceVersion != existing.ResourceVersion:
+			s.respond(w, k8sErrors.NewConflict(gr, name, fmt.Errorf("resource version %s is outdated", body.ResourceVersion)))
+		default:
+			body.UID = existing.UID
+			s.respond(w, s.put(body, false))
+		}
+
+	case http.MethodPatch:
+		// Only server-side apply is supported, merging the data, labels
+		// and annotations.
+		if r.Header.Get("Content-Type") != string(types.ApplyPatchType) {
+			s.respond(w, k8sErrors.NewBadRequest("unsupported patch type"))
+			return
+		}
+		if existing == nil {
+			s.respond(w, s.put(body, true))
+			return
+		}
+		applied := existing.DeepCopy()
+		for _, m := range []*map[string]string{&applied.Labels, &applied.Annotations} {
+			if *m == nil {
+				*m = make(map[string]string)
+			}
+		}
+		if applied.Data == nil {
+			applied.Data = make(map[string][]byte)
+		}
+		maps.Copy(applied.Labels, body.Labels)
+		maps.Copy(applied.Annotations, body.Annotations)
+		maps.Copy(applied.Data, body.Data)
+		s.respond(w, s.put(applied, false))
+
+	case http.MethodDelete:
+		if existing == nil {
+			s.respond(w, k8sErrors.NewNotFound(gr, name))
+			return
+		}
+		delete(s.secrets, name)
+		s.respond(w, &meta_v1.Status{TypeMeta: meta_v1.TypeMeta{Kind: "Status", APIVersion: "v1"}, Status: meta_v1.StatusSuccess})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// respond writes obj, or the status of err if obj is an API error.
+func (s *secretServer) respond(w http.ResponseWriter, obj any) {
+	code := http.StatusOK
+	if err, ok := obj.(*k8sErrors.StatusError); ok {
+		status := err.ErrStatus
+		status.TypeMeta = meta_v1.TypeMeta{Kind: "Status", APIVersion: "v1"}
+		code, obj = int(status.Code), &status
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		s.t.Errorf("failed to write response: %v", err)
+	}
+}
+
+// testSecret returns a secret with value as data.
+func testSecret(name, value string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: "test"},
+		Data:       map[string][]byte{"value": []byte(value)},
+	}
+}
+
+// secretValue returns the value of the stored secret name, or an empty string
+// if it does not exist.
+func secretValue(s *secretServer, name string) string {
+	secret := s.get(name)
+	if secret == nil {
+		return ""
+	}
+	return string(secret.Data["value"])
+}
+
+func TestTransactionRollback(t *testing.T) {
+	initial := []*v1.Secret{testSecret("updated", "initial"), testSecret("deleted", "initial"), testSecret("recreated", "initial")}
+	for _, secret := range initial {
+		secret.ManagedFields = []meta_v1.ManagedFieldsEntry{{Manager: "other", Operation: meta_v1.ManagedFieldsOperationApply}}
+	}
+	s, k8sClient := newSecretServer(t, initial...)
+	tx := NewTransaction(NewSecretSink(k8sClient), 10*time.Second)
+
+	ctx := context.Background()
+	for _, write := range []struct {
+		secret *v1.Secret
+		force  bool
+	}{
+		{secret: testSecret("updated", "first"), force: true},
+		{secret: testSecret("created", "first")},
+		{secret: testSecret("updated", "second"), force: true},
+		{secret: testSecret("deleted", "first"), force: true},
+		{secret: testSecret("recreated", "first"), force: true},
+		{secret: testSecret("created-deleted", "first")},
+		// Secrets which are not forced and already exist are not written.
+		{secret: testSecret("deleted", "second")},
+	} {
+		if err := tx.Store(ctx, write.secret, write.force); err != nil && !k8sErrors.IsAlreadyExists(err) {
+			t.Fatalf("failed to store secret %s: %v", write.secret.Name, err)
+		}
+	}
+	if tx.Writes() != 6 {
+		t.Fatalf("transaction has %d writes, expected 6", tx.Writes())
+	}
+
+	// The secrets are deleted, or deleted and re-created, since they were
+	// written.
+	s.delete("deleted")
+	s.delete("created-deleted")
+	s.delete("recreated")
+	s.mu.Lock()
+	s.put(testSecret("recreated", "other"), true)
+	s.requests = nil
+	s.mu.Unlock()
+
+	result := tx.Rollback()
+	if result.Err != nil {
+		t.Fatalf("failed to roll back transaction: %v", result.Err)
+	}
+	if result.Restored != 4 || result.Deleted != 2 {
+		t.Fatalf("rollback %s, expected 4 secrets restored and 2 deleted", result)
+	}
+	if tx.Writes() != 0 {
+		t.Fatalf("transaction has %d writes after the rollback", tx.Writes())
+	}
+
+	for name, want := range map[string]string{
+		// updated is restored in reverse order, to its value before the
+		// first write.
+		"updated":         "initial",
+		"created":         "",
+		"deleted":         "initial",
+		"recreated":       "initial",
+		"created-deleted": "",
+	} {
+		if got := secretValue(s, name); got != want {
+			t.Fatalf("secret %s has value %q after rollback, expected %q", name, got, want)
+		}
+	}
+
+	var got []string
+	for _, req := range s.requests {
+		got = append(got, req.method+" "+req.name)
+		if req.secret == nil {
+			continue
+		}
+		if len(req.secret.ManagedFields) != 0 {
+			t.Fatalf("%s request of secret %s has managed fields %v", req.method, req.name, req.secret.ManagedFields)
+		}
+		if req.method == http.MethodPost && (req.secret.ResourceVersion != "" || req.secret.UID != "") {
+			t.Fatalf("create request of secret %s has resource version %q and UID %q", req.name, req.secret.ResourceVersion, req.secret.UID)
+		}
+	}
+	want := []string{
+		"DELETE created-deleted",
+		"PUT recreated",
+		"POST deleted",
+		"PUT updated",
+		"DELETE created",
+		"PUT updated",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("rollback sent requests %v, expected %v", got, want)
+	}
+}
+
+func TestTransactionRollbackFailure(t *testing.T) {
+	s, k8sClient := newSecretServer(t, testSecret("a", "initial"), testSecret("b", "initial"))
+	tx := NewTransaction(NewSecretSink(k8sClient), 10*time.Second)
+
+	ctx := context.Background()
+	for _, name := range []string{"a", "b", "c"} {
+		if err := tx.Store(ctx, testSecret(name, "written"), true); err != nil {
+			t.Fatalf("failed to store secret %s: %v", name, err)
+		}
+	}
+	s.mu.Lock()
+	s.failing = []string{"b"}
+	s.mu.Unlock()
+
+	// The secrets are rolled back even if one of them fails.
+	result := tx.Rollback()
+	if result.Err == nil || !strings.Contains(result.Err.Error(), "test/b") {
+		t.Fatalf("expected rollback of secret b to fail, got %v", result.Err)
+	}
+	if result.Restored != 1 || result.Deleted != 1 {
+		t.Fatalf("rollback %s, expected 1 secret restored and 1 deleted", result)
+	}
+	for name, want := range map[string]string{"a": "initial", "b": "written", "c": ""} {
+		if got := secretValue(s, name); got != want {
+			t.Fatalf("secret %s has value %q after rollback, expected %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package generate
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/helpers"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Verify reads the secret of the certificate and checks that its key matches
+// the certificate, that the certificate chains to the CA bundle of the secret
+// and to ca, that its SANs cover c.Hosts and that its extended key usages
+// match c.Usage. It returns a description of every failed check.
+func (c *Cert) Verify(ctx context.Context, k8sClient *kubernetes.Clientset, ca *CA) ([]string, error) {
+	secret, err := k8sClient.CoreV1().Secrets(c.Namespace).Get(ctx, c.Name, meta_v1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		return []string{"secret does not exist, run certgen to create it"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := helpers.ParseCertificatesPEM(secret.Data["tls.crt"])
+	if err != nil || len(chain) == 0 {
+		return []string{"tls.crt is missing or unparsable, run certgen to re-issue the certificate"}, nil
+	}
+	cert := chain[0]
+
+	var failures []string
+	if err := keyMatchesCert(secret.Data["tls.key"], cert); err != nil {
+		failures = append(failures, fmt.Sprintf("tls.key does not match tls.crt (%s), run certgen to re-issue the certificate", err))
+	}
+
+	bundle, _ := helpers.ParseCertificatesPEM(secret.Data["ca.crt"])
+	if err := verifyChain(cert, bundle, chain[1:]); err != nil {
+		failures = append(failures, fmt.Sprintf("tls.crt does not chain to ca.crt of the secret (%s), the CA bundle may be stale", err))
+	}
+	if bundle := ca.bundleCerts(); len(bundle) != 0 {
+		if err := verifyChain(cert, bundle, chain[1:]); err != nil {
+			failures = append(failures, fmt.Sprintf("tls.crt is not issued by the CA bundle of the CSR signer (%s), run certgen to re-issue the certificate", err))
+		}
+	} else if ca == nil || ca.CACert == nil {
+		failures = append(failures, "the current CA is not available, tls.crt cannot be verified against it")
+	} else if err := verifyChain(cert, []*x509.Certificate{ca.CACert}, chain[1:]); err != nil {
+		failures = append(failures, fmt.Sprintf("tls.crt is not issued by the current CA %q (%s), run certgen to re-issue the certificate",
+			ca.CACert.Subject.CommonName, err))
+	}
+
+	hosts := certHosts(cert)
+	for _, host := range c.Hosts {
+		if !slices.Contains(hosts, host) && cert.VerifyHostname(host) != nil {
+			failures = append(failures, fmt.Sprintf("SANs %v do not cover host %q, run certgen to re-issue the certificate", hosts, host))
+		}
+	}
+
+	if missing, unexpected := diffExtKeyUsages(cert, c.Usage); len(missing) != 0 || len(unexpected) != 0 {
+		var diffs []string
+		if len(missing) != 0 {
+			diffs = append(diffs, "missing "+strings.Join(missing, ", "))
+		}
+		if len(unexpected) != 0 {
+			diffs = append(diffs, "unexpected "+strings.Join(unexpected, ", "))
+		}
+		failures = append(failures, fmt.Sprintf("extended key usages do not match the certificate role (%s), run certgen to re-issue the certificate",
+			strings.Join(diffs, "; ")))
+	}
+
+	return failures, nil
+}
+
+// keyMatchesCert returns an error if keyPEM is not the private key of cert.
+func keyMatchesCert(keyPEM []byte, cert *x509.Certificate) error {
+	if len(keyPEM) == 0 {
+		return errors.New("tls.key is missing")
+	}
+	key, err := helpers.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return fmt.Errorf("tls.key is unparsable: %w", err)
+	}
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.PublicKey) {
+		return errors.New("public keys differ")
+	}
+	return nil
+}
+
+// verifyChain verifies cert against roots, using intermediates.
+func verifyChain(cert *x509.Certificate, roots, intermediates []*x509.Certificate) error {
+	if len(roots) == 0 {
+		return errors.New("no CA certificate")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, root := range roots {
+		opts.Roots.AddCert(root)
+	}
+	for _, intermediate := range intermediates {
+		opts.Intermediates.AddCert(intermediate)
+	}
+	_, err := cert.Verify(opts)
+	return err
+}
+
+// diffExtKeyUsages returns the extended key usages which are in usages but not
+// in cert, and the ones which are in cert but not in usages.
+func diffExtKeyUsages(cert *x509.Certificate, usages []string) (missing, unexpected []string) {
+	var expected []x509.ExtKeyUsage
+	for _, usage := range usages {
+		if eku, ok := config.ExtKeyUsage[usage]; ok {
+			expected = append(expected, eku)
+			if !slices.Contains(cert.ExtKeyUsage, eku) {
+				missing = append(missing, usage)
+			}
+		}
+	}
+	for _, eku := range cert.ExtKeyUsage {
+		if !slices.Contains(expected, eku) {
+			name, ok := extUsageNames[eku]
+			if !ok {
+				name = fmt.Sprintf("unknown (%d)", eku)
+			}
+			unexpected = append(unexpected, name)
+		}
+	}
+	return missing, unexpected
+}
@@ -0,0 +1,306 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+
+	"github.com/cilium/certgen/internal/logging"
+	"github.com/cilium/certgen/internal/logging/logfields"
+)
+
+var (
+	log = logging.DefaultLogger.WithField(logfields.LogSubsys, "lock")
+
+	// ErrLost is the cause of the cancellation of the context of a lease
+	// which could not be renewed before expiring, or was taken over by
+	// another holder.
+	ErrLost = errors.New("K8s Lease lost")
+)
+
+const (
+	// LeaseDuration is the duration for which a lease is held without being
+	// renewed. A holder which stops renewing its lease (e.g. because its pod
+	// was killed) loses it after this duration.
+	LeaseDuration = 30 * time.Second
+	// renewInterval is the interval at which a held lease is renewed.
+	renewInterval = LeaseDuration / 3
+	// retryInterval is the interval at which a lease held by another holder
+	// is checked again.
+	retryInterval = 2 * time.Second
+)
+
+// Lease is a lock held on a coordination.k8s.io Lease, which is renewed in the
+// background until it is released.
+type Lease struct {
+	k8sClient      *kubernetes.Clientset
+	namespace      string
+	name           string
+	identity       string
+	requestTimeout time.Duration
+
+	mu      sync.Mutex
+	lease   *coordinationv1.Lease
+	renewed time.Time
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// Acquire acquires the Lease namespace/name, creating it if it does not
+// exist. If the lease is held by another holder, it waits until the lease is
+// released or expires, and fails once timeout has elapsed. requestTimeout
+// bounds each request made to renew and release the lease. The context of the
+// returned lease is canceled if the lease is lost while held.
+func Acquire(k8sClient *kubernetes.Clientset, namespace, name string, timeout, requestTimeout time.Duration) (*Lease, error) {
+	identity, err := newIdentity()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate lease holder identity: %w", err)
+	}
+	l := &Lease{
+		k8sClient:      k8sClient,
+		namespace:      namespace,
+		name:           name,
+		identity:       identity,
+		requestTimeout: requestTimeout,
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	l.ctx, l.cancel = context.WithCancelCause(context.Background())
+	scopedLog := log.WithFields(logrus.Fields{
+		logfields.K8sLeaseNamespace: namespace,
+		logfields.K8sLeaseName:      name,
+		logfields.LeaseHolder:       identity,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var holder string
+	for {
+		acquired, current, err := l.tryAcquire(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to acquire lease %s/%s: %w", namespace, name, err)
+		}
+		if acquired {
+			break
+		}
+		if current != holder {
+			holder = current
+			scopedLog.WithField(logfields.LeaseCurrentHolder, holder).Info("Waiting for K8s Lease held by another certgen run")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out after %s waiting for lease %s/%s held by %s", timeout, namespace, name, holder)
+		case <-time.After(retryInterval):
+		}
+	}
+	l.renewed = time.Now()
+	scopedLog.Info("Acquired K8s Lease")
+
+	go l.renew()
+	return l, nil
+}
+
+// tryAcquire makes one attempt at acquiring the lease. If the lease is held by
+// another holder, it returns false and the identity of that holder.
+func (l *Lease) tryAcquire(ctx context.Context) (bool, string, error) {
+	leases := l.k8sClient.CoordinationV1().Leases(l.namespace)
+	now := meta_v1.NewMicroTime(time.Now())
+
+	current, err := leases.Get(ctx, l.name, meta_v1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		lease := &coordinationv1.Lease{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Name:      l.name,
+				Namespace: l.namespace,
+			},
+		}
+		l.hold(lease, now, true)
+		created, err := leases.Create(ctx, lease, meta_v1.CreateOptions{})
+		if k8sErrors.IsAlreadyExists(err) {
+			// Created concurrently by another holder, check it again.
+			return l.tryAcquire(ctx)
+		}
+		if err != nil {
+			return false, "", err
+		}
+		l.lease = created
+		return true, "", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+
+	if isHeld(current, now.Time) {
+		return false, ptr.Deref(current.Spec.HolderIdentity, ""), nil
+	}
+
+	lease := current.DeepCopy()
+	l.hold(lease, now, true)
+	updated, err := leases.Update(ctx, lease, meta_v1.UpdateOptions{})
+	if k8sErrors.IsConflict(err) {
+		// Taken over concurrently by another holder, retry.
+		return false, ptr.Deref(current.Spec.HolderIdentity, ""), nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	l.lease = updated
+	return true, "", nil
+}
+
+// hold sets l as the holder of lease, renewed at now.
+func (l *Lease) hold(lease *coordinationv1.Lease, now meta_v1.MicroTime, acquire bool) {
+	lease.Spec.HolderIdentity = ptr.To(l.identity)
+	lease.Spec.LeaseDurationSeconds = ptr.To(int32(LeaseDuration / time.Second))
+	lease.Spec.RenewTime = &now
+	if acquire {
+		lease.Spec.AcquireTime = &now
+		lease.Spec.LeaseTransitions = ptr.To(ptr.Deref(lease.Spec.LeaseTransitions, 0) + 1)
+	}
+}
+
+// isHeld returns true if lease has a holder which renewed it less than its
+// duration ago.
+func isHeld(lease *coordinationv1.Lease, now time.Time) bool {
+	if ptr.Deref(lease.Spec.HolderIdentity, "") == "" || lease.Spec.RenewTime == nil {
+		return false
+	}
+	duration := time.Duration(ptr.Deref(lease.Spec.LeaseDurationSeconds, 0)) * time.Second
+	return now.Before(lease.Spec.RenewTime.Add(duration))
+}
+
+// renew renews the lease every renewInterval until the lease is released. The
+// lease is lost, and its context canceled, once it is modified by another
+// holder or would expire before the next renewal.
+func (l *Lease) renew() {
+	defer close(l.done)
+
+	scopedLog := log.WithFields(logrus.Fields{
+		logfields.K8sLeaseNamespace: l.namespace,
+		logfields.K8sLeaseName:      l.name,
+	})
+	ticker := time.NewTicker(renewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		err := l.renewOnce()
+		if err == nil {
+			continue
+		}
+		if k8sErrors.IsConflict(err) {
+			scopedLog.WithError(err).Error("K8s Lease was modified by another holder, aborting")
+			l.cancel(fmt.Errorf("%w: lease %s/%s was modified by another holder", ErrLost, l.namespace, l.name))
+			return
+		}
+		l.mu.Lock()
+		expiry := l.renewed.Add(LeaseDuration)
+		l.mu.Unlock()
+		if time.Now().Add(renewInterval).After(expiry) {
+			scopedLog.WithError(err).Error("Failed to renew K8s Lease before it expires, aborting")
+			l.cancel(fmt.Errorf("%w: failed to renew lease %s/%s before it expires: %w", ErrLost, l.namespace, l.name, err))
+			return
+		}
+		scopedLog.WithError(err).Warn("Failed to renew K8s Lease")
+	}
+}
+
+// renewOnce updates the renew time of the lease.
+func (l *Lease) renewOnce() error {
+	ctx, cancel := context.WithTimeout(context.Background(), l.requestTimeout)
+	defer cancel()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	lease := l.lease.DeepCopy()
+	l.hold(lease, meta_v1.NewMicroTime(now), false)
+	updated, err := l.k8sClient.CoordinationV1().Leases(l.namespace).Update(ctx, lease, meta_v1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+	l.lease = updated
+	l.renewed = now
+	return nil
+}
+
+// Context returns a context which is canceled, with an error wrapping ErrLost
+// as cause, once the lease is lost. The work protected by the lease must stop
+// once it is canceled, as another holder may have acquired the lease.
+func (l *Lease) Context() context.Context {
+	return l.ctx
+}
+
+// Release stops renewing the lease and releases it, so that other holders do
+// not need to wait for it to expire. A lost lease is left untouched.
+func (l *Lease) Release() error {
+	close(l.stop)
+	<-l.done
+	defer l.cancel(context.Canceled)
+	if l.ctx.Err() != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.requestTimeout)
+	defer cancel()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lease := l.lease.DeepCopy()
+	lease.Spec.HolderIdentity = nil
+	lease.Spec.RenewTime = nil
+	lease.Spec.AcquireTime = nil
+	_, err := l.k8sClient.CoordinationV1().Leases(l.namespace).Update(ctx, lease, meta_v1.UpdateOptions{})
+	if k8sErrors.IsConflict(err) {
+		return fmt.Errorf("failed to release lease %s/%s: it was modified by another holder, it may have expired before being released", l.namespace, l.name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to release lease %s/%s: %w", l.namespace, l.name, err)
+	}
+	log.WithFields(logrus.Fields{
+		logfields.K8sLeaseNamespace: l.namespace,
+		logfields.K8sLeaseName:      l.name,
+	}).Info("Released K8s Lease")
+	return nil
+}
+
+// newIdentity returns the identity of the lease holder, made of the hostname
+// (the pod name when running in K8s) and a random suffix, so that concurrent
+// runs in the same pod do not share it.
+func newIdentity() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return hostname + "_" + hex.EncodeToString(suffix), nil
+}
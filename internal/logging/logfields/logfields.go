@@ -10,6 +10,10 @@ const (
 	// LogSyslog is the field denoting the syslog level when logging.
 	LogSyslog = "syslog"
 
+	// CAName is the field denoting the name of a CA spec.
+	CAName = "caName"
+	// CertName is the field denoting the name of a certificate spec.
+	CertName = "certName"
 	// CertCommonName is the field denoting a x509 certificate's CN.
 	CertCommonName = "certCommonName"
 	// CertValidityDuration is the field denoting a x509 certificate's validity
@@ -17,9 +21,58 @@ const (
 	CertValidityDuration = "certValidityDuration"
 	// CertUsage is the field denoting a x509 certificate's key usages.
 	CertUsage = "certUsage"
+	// CertSerialNumber is the field denoting a x509 certificate's serial
+	// number.
+	CertSerialNumber = "certSerialNumber"
 
+	// Action is the field denoting the action taken for a certificate.
+	Action = "action"
+	// Reason is the field denoting why an action is taken.
+	Reason = "reason"
+	// RenewAt is the field denoting when a certificate is due for renewal.
+	RenewAt = "renewAt"
+	// Count is the field denoting the number of processed items.
+	Count = "count"
+
+	// RotationPhase is the field denoting the phase of a CA rotation.
+	RotationPhase = "rotationPhase"
+
+	// Path is the field denoting a filesystem path.
+	Path = "path"
+
+	// K8sSecret is the field denoting a Kubernetes secret as namespace/name.
+	K8sSecret = "k8sSecret"
 	// K8sSecretName is the field denoting a Kubernetes secret name.
 	K8sSecretName = "k8sSecretName"
 	// K8sSecretNamespace is the field denoting a Kubernetes secret's namespace.
 	K8sSecretNamespace = "k8sSecretNamespace"
+	// K8sConfigMapName is the field denoting a Kubernetes ConfigMap name.
+	K8sConfigMapName = "k8sConfigMapName"
+	// K8sConfigMapNamespace is the field denoting a Kubernetes ConfigMap's
+	// namespace.
+	K8sConfigMapNamespace = "k8sConfigMapNamespace"
+	// K8sLeaseName is the field denoting a Kubernetes lease name.
+	K8sLeaseName = "k8sLeaseName"
+	// K8sLeaseNamespace is the field denoting a Kubernetes lease's namespace.
+	K8sLeaseNamespace = "k8sLeaseNamespace"
+	// LeaseHolder is the field denoting the identity with which a lease is
+	// acquired.
+	LeaseHolder = "leaseHolder"
+	// LeaseCurrentHolder is the field denoting the identity of the current
+	// holder of a lease.
+	LeaseCurrentHolder = "leaseCurrentHolder"
+
+	// RemoteSignerHosts is the field denoting the URLs of remote cfssl
+	// servers.
+	RemoteSignerHosts = "remoteSignerHosts"
+	// K8sCSRName is the field denoting a Kubernetes CertificateSigningRequest
+	// name.
+	K8sCSRName = "k8sCSRName"
+	// K8sSignerName is the field denoting the signer name of a Kubernetes
+	// CertificateSigningRequest.
+	K8sSignerName = "k8sSignerName"
+	// K8sKind is the field denoting the kind of a Kubernetes object.
+	K8sKind = "k8sKind"
+	// K8sObject is the field denoting a Kubernetes object as namespace/name.
+	K8sObject = "k8sObject"
 )
@@ -4,9 +4,34 @@
 package option
 
 import (
+	"fmt"
+	"net"
+	"slices"
 	"time"
 
+	"github.com/cloudflare/cfssl/config"
 	"github.com/spf13/viper"
+
+	"github.com/cilium/certgen/internal/generate"
+)
+
+const (
+	// OutputSinkSecret stores the generated CAs and certificates as K8s
+	// Secrets.
+	OutputSinkSecret = "secret"
+	// OutputSinkFile stores the generated CAs and certificates as PEM files.
+	OutputSinkFile = "file"
+	// OutputSinkManifest prints the generated CAs and certificates as K8s
+	// Secret manifests to stdout.
+	OutputSinkManifest = "manifest"
+)
+
+const (
+	// CertManagerModeEmit prints the cert-manager resources as K8s manifests
+	// to stdout.
+	CertManagerModeEmit = "emit"
+	// CertManagerModeApply applies the cert-manager resources to the cluster.
+	CertManagerModeApply = "apply"
 )
 
 // Config is the main configuration as obtained from command-line arguments,
@@ -17,6 +42,51 @@ const (
 	// Debug enables debug messages.
 	Debug = "debug"
 
+	// SpecFile is the path to a YAML or JSON file listing the certificates to
+	// generate in addition to the ones enabled by the per-component options.
+	SpecFile = "spec-file"
+
+	// DryRun can be set to true to only print the changes certgen would make
+	// to the CA and certificate secrets, without writing any secret.
+	DryRun = "dry-run"
+	// DryRunOutput is the format of the plan printed in dry-run mode (text or
+	// json).
+	DryRunOutput = "dry-run-output"
+
+	// OutputSinks lists where the generated CAs and certificates are stored:
+	// as K8s Secrets (secret), as PEM files in OutputDir (file) and/or as
+	// Secret manifests printed to stdout (manifest).
+	OutputSinks = "output-sinks"
+	// OutputDir is the directory in which the file sink writes a
+	// <namespace>/<name> directory per secret.
+	OutputDir = "output-dir"
+
+	// RenderOutput is the path to the file the render command writes the
+	// Secret manifests to, or empty for stdout.
+	RenderOutput = "render-output"
+
+	// ReportOutput is the path to the file the JSON run report is written
+	// to, "-" for stdout, or empty to not write it to a file.
+	ReportOutput = "report-output"
+	// ReportConfigMapName is the name of the K8s ConfigMap the JSON run
+	// report is stored in, or empty to not store it in a ConfigMap.
+	ReportConfigMapName = "report-configmap-name"
+	// ReportConfigMapNamespace is the namespace of the K8s ConfigMap the
+	// JSON run report is stored in.
+	ReportConfigMapNamespace = "report-configmap-namespace"
+
+	// Events can be set to true to emit K8s Events on the CA and
+	// certificate secrets, and on the certgen Job or Pod.
+	Events = "events"
+
+	// InspectOutput is the format of the report printed by the inspect
+	// command, either text or json.
+	InspectOutput = "inspect-output"
+	// InspectWarnBefore is the window before expiry in which the inspect
+	// command reports certificates as expiring, as duration or percentage of
+	// the lifetime.
+	InspectWarnBefore = "inspect-warn-before"
+
 	// CiliumNamespace is the Kubernetes namespace in which Cilium is
 	// installed.
 	CiliumNamespace = "cilium-namespace"
@@ -26,12 +96,127 @@ const (
 	K8sKubeConfigPath = "k8s-kubeconfig-path"
 	// K8sRequestTimeout specifies the timeout for K8s API requests.
 	K8sRequestTimeout = "k8s-request-timeout"
+	// K8sClientQPS is the maximum sustained rate of K8s API requests per
+	// second.
+	K8sClientQPS = "k8s-client-qps"
+	// K8sClientBurst is the maximum burst of K8s API requests.
+	K8sClientBurst = "k8s-client-burst"
+
+	// Workers is the number of certificates generated and stored
+	// concurrently. The number of CPUs is used if it is not positive.
+	Workers = "workers"
+
+	// ForceConflicts can be set to true to take ownership of the secret
+	// fields managed by other field managers when applying secrets.
+	ForceConflicts = "force-conflicts"
+
+	// LeaseLock can be set to true to acquire a K8s Lease before loading the
+	// CAs and writing the secrets, so that concurrent runs are serialized.
+	LeaseLock = "lease-lock"
+	// LeaseLockName is the name of the K8s Lease used as lock.
+	LeaseLockName = "lease-lock-name"
+	// LeaseLockNamespace is the namespace of the K8s Lease used as lock.
+	LeaseLockNamespace = "lease-lock-namespace"
+	// LeaseLockTimeout is the maximum time to wait for the K8s Lease to be
+	// released by another run.
+	LeaseLockTimeout = "lease-lock-timeout"
 
 	// CACertFile is the path to the Cilium CA cert PEM (if CAGenerate is
 	// false).
 	CACertFile = "ca-cert-file"
 	// CAKeyFile is the path to the Cilium CA key PEM (if CAGenerate is false).
 	CAKeyFile = "ca-key-file"
+	// CAStoreFile can be set to true to store the Cilium CA loaded from
+	// CACertFile and CAKeyFile in the CA secret. The existing secret is only
+	// overwritten if CAReuseSecret is false.
+	CAStoreFile = "ca-store-file"
+	// CAKeyPassphraseFile is the path to the file holding the passphrase of
+	// the encrypted Cilium CA key.
+	CAKeyPassphraseFile = "ca-key-passphrase-file"
+	// CAKeyPassphraseEnv is the name of the environment variable holding the
+	// passphrase of the encrypted Cilium CA key.
+	CAKeyPassphraseEnv = "ca-key-passphrase-env"
+	// CAKeyPassphraseSecretName is the name of the K8s Secret holding the
+	// passphrase of the encrypted Cilium CA key.
+	CAKeyPassphraseSecretName = "ca-key-passphrase-secret-name"
+	// CAKeyPassphraseSecretNamespace is the namespace of the K8s Secret
+	// holding the passphrase of the encrypted Cilium CA key.
+	CAKeyPassphraseSecretNamespace = "ca-key-passphrase-secret-namespace"
+	// CAKeyPassphraseSecretKey is the key of the K8s Secret holding the
+	// passphrase of the encrypted Cilium CA key.
+	CAKeyPassphraseSecretKey = "ca-key-passphrase-secret-key"
+	// CAKeyEncrypt can be set to true to encrypt the Cilium CA key stored in
+	// the CA secret with the CA key passphrase.
+	CAKeyEncrypt = "ca-key-encrypt"
+
+	// RemoteSignerURL is the URL of the remote cfssl server issuing the
+	// certificates instead of the Cilium CA.
+	RemoteSignerURL = "remote-signer-url"
+	// RemoteSignerProfile is the signing profile requested from the remote
+	// cfssl server.
+	RemoteSignerProfile = "remote-signer-profile"
+	// RemoteSignerLabel is the label selecting the CA of a multi-root remote
+	// cfssl server.
+	RemoteSignerLabel = "remote-signer-label"
+	// RemoteSignerAuthKeyFile is the path to the file holding the hex encoded
+	// key authenticating the requests to the remote cfssl server.
+	RemoteSignerAuthKeyFile = "remote-signer-auth-key-file"
+	// RemoteSignerTLSCAFile is the path to the CA certificates verifying the
+	// TLS certificate of the remote cfssl server.
+	RemoteSignerTLSCAFile = "remote-signer-tls-ca-file"
+	// RemoteSignerCAChainFile is the path to the parent CA certificates of
+	// the CA of the remote cfssl server up to the root, if it is an
+	// intermediate CA.
+	RemoteSignerCAChainFile = "remote-signer-ca-chain-file"
+	// RemoteSignerTimeout is the timeout of the requests to the remote cfssl
+	// server.
+	RemoteSignerTimeout = "remote-signer-timeout"
+
+	// CSRSignerName is the signer name of the K8s CertificateSigningRequests
+	// through which the certificates are issued instead of the Cilium CA.
+	CSRSignerName = "csr-signer-name"
+	// CSRAutoApprove can be set to true to approve the K8s
+	// CertificateSigningRequests created by certgen.
+	CSRAutoApprove = "csr-auto-approve"
+	// CSRTimeout is the maximum time to wait for a K8s
+	// CertificateSigningRequest to be issued.
+	CSRTimeout = "csr-timeout"
+	// CSRCABundleConfigMapName is the name of the K8s ConfigMap holding the
+	// CA bundle of the CSR signer.
+	CSRCABundleConfigMapName = "csr-ca-bundle-configmap-name"
+	// CSRCABundleSecretName is the name of the K8s Secret holding the CA
+	// bundle of the CSR signer.
+	CSRCABundleSecretName = "csr-ca-bundle-secret-name"
+	// CSRCABundleNamespace is the namespace of the K8s ConfigMap or Secret
+	// holding the CA bundle of the CSR signer.
+	CSRCABundleNamespace = "csr-ca-bundle-namespace"
+	// CSRCABundleKey is the key of the K8s ConfigMap or Secret holding the
+	// CA bundle of the CSR signer.
+	CSRCABundleKey = "csr-ca-bundle-key"
+
+	// CASignerName is the signer name of the K8s CertificateSigningRequests
+	// the controller signs with the Cilium CA.
+	CASignerName = "ca-signer-name"
+	// CASignerAllowedUsages are the key usages which may be requested from
+	// the Cilium CA signer.
+	CASignerAllowedUsages = "ca-signer-allowed-usages"
+	// CASignerAllowedDNSNames are the DNS names which may be requested from
+	// the Cilium CA signer.
+	CASignerAllowedDNSNames = "ca-signer-allowed-dns-names"
+	// CASignerAllowedIPRanges are the CIDRs of the IP addresses which may be
+	// requested from the Cilium CA signer.
+	CASignerAllowedIPRanges = "ca-signer-allowed-ip-ranges"
+	// CASignerMaxDuration is the maximum validity of the certificates signed
+	// by the Cilium CA signer.
+	CASignerMaxDuration = "ca-signer-max-duration"
+
+	// CertManagerMode can be set to emit or apply a cert-manager Issuer backed
+	// by the Cilium CA secret and a cert-manager Certificate per certificate,
+	// instead of issuing the certificates.
+	CertManagerMode = "cert-manager-mode"
+	// CertManagerIssuerName is the name of the cert-manager Issuer backed by
+	// the Cilium CA secret.
+	CertManagerIssuerName = "cert-manager-issuer-name"
 
 	// CAGenerate can be set to true to generate a new Cilium CA secret.
 	// If CAReuseSecret is true, then a new CA secret only is created if
@@ -46,12 +231,78 @@ const (
 	// CAValidityDuration represent how much time the Cilium CA certificate
 	// generated by certgen is valid.
 	CAValidityDuration = "ca-validity-duration"
+	// CAKeyAlgorithm is the algorithm of the Cilium CA private key (ecdsa,
+	// rsa or ed25519).
+	CAKeyAlgorithm = "ca-key-algorithm"
+	// CAKeySize is the size of the Cilium CA private key, in bits for RSA
+	// keys and as curve size for ECDSA keys.
+	CAKeySize = "ca-key-size"
+	// CACountry is the Cilium CA x509 certificate country (C) value.
+	CACountry = "ca-country"
+	// CAState is the Cilium CA x509 certificate state or province (ST) value.
+	CAState = "ca-state"
+	// CALocality is the Cilium CA x509 certificate locality (L) value.
+	CALocality = "ca-locality"
+	// CAOrganization is the Cilium CA x509 certificate organization (O) value.
+	CAOrganization = "ca-organization"
+	// CAOrganizationalUnit is the Cilium CA x509 certificate organizational unit (OU) value.
+	CAOrganizationalUnit = "ca-organizational-unit"
+	// CASerialNumber is the Cilium CA x509 certificate subject serial number value.
+	CASerialNumber = "ca-serial-number"
 	// CASecretName is the Kubernetes Secret in which the Cilium CA certificate
 	// is read from and/or written to.
 	CASecretName = "ca-secret-name"
 	// CASecretNamespace is the Kubernetes Namespace in which the Cilium CA
 	// Secret will be stored.
 	CASecretNamespace = "ca-secret-namespace"
+	// CARotate can be set to true to start a phased rotation of the Cilium
+	// CA stored in the CA secret, if none is in progress. In-progress
+	// rotations are moved forward on every run regardless of this option.
+	CARotate = "ca-rotate"
+	// CARotationGracePeriod is the minimum time between two phases of a
+	// Cilium CA rotation, which should leave enough time for all the
+	// components to pick up the updated certificates and CA bundles.
+	CARotationGracePeriod = "ca-rotation-grace-period"
+
+	// CertReuseSecret can be set to true to keep the existing certificate
+	// secrets unless they are missing, unparsable or due for renewal.
+	CertReuseSecret = "cert-reuse-secret" //#nosec
+	// CertRenewBefore is the window before expiry in which certificates are
+	// renewed if CertReuseSecret is true, either as duration (e.g. "720h") or
+	// as percentage of the certificate lifetime (e.g. "33%").
+	CertRenewBefore = "cert-renew-before"
+	// CertKeyAlgorithm is the algorithm of the certificate private keys
+	// (ecdsa, rsa or ed25519), unless specified otherwise in the spec file.
+	CertKeyAlgorithm = "cert-key-algorithm"
+	// CertKeySize is the size of the certificate private keys, in bits for
+	// RSA keys and as curve size for ECDSA keys, unless specified otherwise
+	// in the spec file.
+	CertKeySize = "cert-key-size"
+	// CertCountry is the x509 certificate country (C) value, unless specified
+	// otherwise in the spec file.
+	CertCountry = "cert-country"
+	// CertState is the x509 certificate state or province (ST) value, unless specified
+	// otherwise in the spec file.
+	CertState = "cert-state"
+	// CertLocality is the x509 certificate locality (L) value, unless specified
+	// otherwise in the spec file.
+	CertLocality = "cert-locality"
+	// CertOrganization is the x509 certificate organization (O) value, unless specified
+	// otherwise in the spec file.
+	CertOrganization = "cert-organization"
+	// CertOrganizationalUnit is the x509 certificate organizational unit (OU) value, unless specified
+	// otherwise in the spec file.
+	CertOrganizationalUnit = "cert-organizational-unit"
+	// CertSerialNumber is the x509 certificate subject serial number value, unless specified
+	// otherwise in the spec file.
+	CertSerialNumber = "cert-serial-number"
+
+	// SecretLabels are extra labels set on every CA and certificate secret,
+	// in addition to the ones set in the spec file.
+	SecretLabels = "secret-labels"
+	// SecretAnnotations are extra annotations set on every CA and certificate
+	// secret, in addition to the ones set in the spec file.
+	SecretAnnotations = "secret-annotations"
 
 	// HubbleServerCertGenerate can be set to true to generate and store a
 	// Hubble server TLS certificate.
@@ -180,6 +431,58 @@ type CertGenConfig struct {
 	// Debug enables debug messages.
 	Debug bool
 
+	// SpecFile is the path to a YAML or JSON file listing the certificates to
+	// generate in addition to the ones enabled by the per-component options.
+	SpecFile string
+
+	// DryRun can be set to true to only print the changes certgen would make
+	// to the CA and certificate secrets, without writing any secret.
+	DryRun bool
+	// DryRunOutput is the format of the plan printed in dry-run mode (text or
+	// json).
+	DryRunOutput string
+
+	// OutputSinks lists where the generated CAs and certificates are stored:
+	// as K8s Secrets (secret), as PEM files in OutputDir (file) and/or as
+	// Secret manifests printed to stdout (manifest).
+	OutputSinks []string
+	// OutputDir is the directory in which the file sink writes a
+	// <namespace>/<name> directory per secret.
+	OutputDir string
+
+	// RenderOutput is the path to the file the render command writes the
+	// Secret manifests to, or empty for stdout.
+	RenderOutput string
+
+	// ReportOutput is the path to the file the JSON run report is written
+	// to, "-" for stdout, or empty to not write it to a file.
+	ReportOutput string
+	// ReportConfigMapName is the name of the K8s ConfigMap the JSON run
+	// report is stored in, or empty to not store it in a ConfigMap.
+	ReportConfigMapName string
+	// ReportConfigMapNamespace is the namespace of the K8s ConfigMap the
+	// JSON run report is stored in.
+	ReportConfigMapNamespace string
+
+	// Events can be set to true to emit K8s Events on the CA and
+	// certificate secrets, and on the certgen Job or Pod.
+	Events bool
+
+	// InspectOutput is the format of the report printed by the inspect
+	// command, either text or json.
+	InspectOutput string
+	// InspectWarnBefore is the window before expiry in which the inspect
+	// command reports certificates as expiring, as duration or percentage of
+	// the lifetime.
+	InspectWarnBefore string
+
+	// CAs are the additional CAs certificates can be issued by, as listed in
+	// the spec file.
+	CAs []CASpec
+	// Certificates are the certificates to generate, including the ones
+	// enabled by the per-component options.
+	Certificates []CertificateSpec
+
 	// CiliumNamespace is the Kubernetes namespace in which Cilium is
 	// installed.
 	CiliumNamespace string
@@ -189,12 +492,127 @@ type CertGenConfig struct {
 	K8sKubeConfigPath string
 	// K8sRequestTimeout specifies the timeout for K8s API requests
 	K8sRequestTimeout time.Duration
+	// K8sClientQPS is the maximum sustained rate of K8s API requests per
+	// second.
+	K8sClientQPS float32
+	// K8sClientBurst is the maximum burst of K8s API requests.
+	K8sClientBurst int
+
+	// Workers is the number of certificates generated and stored
+	// concurrently. The number of CPUs is used if it is not positive.
+	Workers int
+
+	// ForceConflicts can be set to true to take ownership of the secret
+	// fields managed by other field managers when applying secrets.
+	ForceConflicts bool
+
+	// LeaseLock can be set to true to acquire a K8s Lease before loading the
+	// CAs and writing the secrets, so that concurrent runs are serialized.
+	LeaseLock bool
+	// LeaseLockName is the name of the K8s Lease used as lock.
+	LeaseLockName string
+	// LeaseLockNamespace is the namespace of the K8s Lease used as lock.
+	LeaseLockNamespace string
+	// LeaseLockTimeout is the maximum time to wait for the K8s Lease to be
+	// released by another run.
+	LeaseLockTimeout time.Duration
 
 	// CACertFile is the path to the Cilium CA cert PEM (if CAGenerate is
 	// false).
 	CACertFile string
 	// CAKeyFile is the path to the Cilium CA key PEM (if CAGenerate is false).
 	CAKeyFile string
+	// CAStoreFile can be set to true to store the Cilium CA loaded from
+	// CACertFile and CAKeyFile in the CA secret. The existing secret is only
+	// overwritten if CAReuseSecret is false.
+	CAStoreFile bool
+	// CAKeyPassphraseFile is the path to the file holding the passphrase of
+	// the encrypted Cilium CA key.
+	CAKeyPassphraseFile string
+	// CAKeyPassphraseEnv is the name of the environment variable holding the
+	// passphrase of the encrypted Cilium CA key.
+	CAKeyPassphraseEnv string
+	// CAKeyPassphraseSecretName is the name of the K8s Secret holding the
+	// passphrase of the encrypted Cilium CA key.
+	CAKeyPassphraseSecretName string
+	// CAKeyPassphraseSecretNamespace is the namespace of the K8s Secret
+	// holding the passphrase of the encrypted Cilium CA key.
+	CAKeyPassphraseSecretNamespace string
+	// CAKeyPassphraseSecretKey is the key of the K8s Secret holding the
+	// passphrase of the encrypted Cilium CA key.
+	CAKeyPassphraseSecretKey string
+	// CAKeyEncrypt can be set to true to encrypt the Cilium CA key stored in
+	// the CA secret with the CA key passphrase.
+	CAKeyEncrypt bool
+
+	// RemoteSignerURL is the URL of the remote cfssl server issuing the
+	// certificates instead of the Cilium CA.
+	RemoteSignerURL string
+	// RemoteSignerProfile is the signing profile requested from the remote
+	// cfssl server.
+	RemoteSignerProfile string
+	// RemoteSignerLabel is the label selecting the CA of a multi-root remote
+	// cfssl server.
+	RemoteSignerLabel string
+	// RemoteSignerAuthKeyFile is the path to the file holding the hex encoded
+	// key authenticating the requests to the remote cfssl server.
+	RemoteSignerAuthKeyFile string
+	// RemoteSignerTLSCAFile is the path to the CA certificates verifying the
+	// TLS certificate of the remote cfssl server.
+	RemoteSignerTLSCAFile string
+	// RemoteSignerCAChainFile is the path to the parent CA certificates of
+	// the CA of the remote cfssl server up to the root, if it is an
+	// intermediate CA.
+	RemoteSignerCAChainFile string
+	// RemoteSignerTimeout is the timeout of the requests to the remote cfssl
+	// server.
+	RemoteSignerTimeout time.Duration
+
+	// CSRSignerName is the signer name of the K8s CertificateSigningRequests
+	// through which the certificates are issued instead of the Cilium CA.
+	CSRSignerName string
+	// CSRAutoApprove can be set to true to approve the K8s
+	// CertificateSigningRequests created by certgen.
+	CSRAutoApprove bool
+	// CSRTimeout is the maximum time to wait for a K8s
+	// CertificateSigningRequest to be issued.
+	CSRTimeout time.Duration
+	// CSRCABundleConfigMapName is the name of the K8s ConfigMap holding the
+	// CA bundle of the CSR signer.
+	CSRCABundleConfigMapName string
+	// CSRCABundleSecretName is the name of the K8s Secret holding the CA
+	// bundle of the CSR signer.
+	CSRCABundleSecretName string
+	// CSRCABundleNamespace is the namespace of the K8s ConfigMap or Secret
+	// holding the CA bundle of the CSR signer.
+	CSRCABundleNamespace string
+	// CSRCABundleKey is the key of the K8s ConfigMap or Secret holding the
+	// CA bundle of the CSR signer.
+	CSRCABundleKey string
+
+	// CASignerName is the signer name of the K8s CertificateSigningRequests
+	// the controller signs with the Cilium CA.
+	CASignerName string
+	// CASignerAllowedUsages are the key usages which may be requested from
+	// the Cilium CA signer.
+	CASignerAllowedUsages []string
+	// CASignerAllowedDNSNames are the DNS names which may be requested from
+	// the Cilium CA signer.
+	CASignerAllowedDNSNames []string
+	// CASignerAllowedIPRanges are the CIDRs of the IP addresses which may be
+	// requested from the Cilium CA signer.
+	CASignerAllowedIPRanges []string
+	// CASignerMaxDuration is the maximum validity of the certificates signed
+	// by the Cilium CA signer.
+	CASignerMaxDuration time.Duration
+
+	// CertManagerMode can be set to emit or apply a cert-manager Issuer backed
+	// by the Cilium CA secret and a cert-manager Certificate per certificate,
+	// instead of issuing the certificates.
+	CertManagerMode string
+	// CertManagerIssuerName is the name of the cert-manager Issuer backed by
+	// the Cilium CA secret.
+	CertManagerIssuerName string
 
 	// CAGenerate can be set to true to generate a new Cilium CA secret.  If
 	// CAReuseSecret is true, then a new CA secret only is created if existing
@@ -209,12 +627,78 @@ type CertGenConfig struct {
 	// CAValidityDuration represent how much time the Cilium CA certificate
 	// generated by certgen is valid.
 	CAValidityDuration time.Duration
+	// CAKeyAlgorithm is the algorithm of the Cilium CA private key (ecdsa,
+	// rsa or ed25519).
+	CAKeyAlgorithm string
+	// CAKeySize is the size of the Cilium CA private key, in bits for RSA
+	// keys and as curve size for ECDSA keys.
+	CAKeySize int
+	// CACountry is the Cilium CA x509 certificate country (C) value.
+	CACountry string
+	// CAState is the Cilium CA x509 certificate state or province (ST) value.
+	CAState string
+	// CALocality is the Cilium CA x509 certificate locality (L) value.
+	CALocality string
+	// CAOrganization is the Cilium CA x509 certificate organization (O) value.
+	CAOrganization string
+	// CAOrganizationalUnit is the Cilium CA x509 certificate organizational unit (OU) value.
+	CAOrganizationalUnit string
+	// CASerialNumber is the Cilium CA x509 certificate subject serial number value.
+	CASerialNumber string
 	// CASecretName is the Kubernetes Secret in which the Cilium CA certificate
 	// is read from and/or written to.
 	CASecretName string
 	// CASecretNamespace is the Kubernetes Namespace in which the Cilium CA
 	// Secret will be stored.
 	CASecretNamespace string
+	// CARotate can be set to true to start a phased rotation of the Cilium
+	// CA stored in the CA secret, if none is in progress. In-progress
+	// rotations are moved forward on every run regardless of this option.
+	CARotate bool
+	// CARotationGracePeriod is the minimum time between two phases of a
+	// Cilium CA rotation, which should leave enough time for all the
+	// components to pick up the updated certificates and CA bundles.
+	CARotationGracePeriod time.Duration
+
+	// CertReuseSecret can be set to true to keep the existing certificate
+	// secrets unless they are missing, unparsable or due for renewal.
+	CertReuseSecret bool
+	// CertRenewBefore is the window before expiry in which certificates are
+	// renewed if CertReuseSecret is true, either as duration (e.g. "720h") or
+	// as percentage of the certificate lifetime (e.g. "33%").
+	CertRenewBefore string
+	// CertKeyAlgorithm is the algorithm of the certificate private keys
+	// (ecdsa, rsa or ed25519), unless specified otherwise in the spec file.
+	CertKeyAlgorithm string
+	// CertKeySize is the size of the certificate private keys, in bits for
+	// RSA keys and as curve size for ECDSA keys, unless specified otherwise
+	// in the spec file.
+	CertKeySize int
+	// CertCountry is the x509 certificate country (C) value, unless specified
+	// otherwise in the spec file.
+	CertCountry string
+	// CertState is the x509 certificate state or province (ST) value, unless specified
+	// otherwise in the spec file.
+	CertState string
+	// CertLocality is the x509 certificate locality (L) value, unless specified
+	// otherwise in the spec file.
+	CertLocality string
+	// CertOrganization is the x509 certificate organization (O) value, unless specified
+	// otherwise in the spec file.
+	CertOrganization string
+	// CertOrganizationalUnit is the x509 certificate organizational unit (OU) value, unless specified
+	// otherwise in the spec file.
+	CertOrganizationalUnit string
+	// CertSerialNumber is the x509 certificate subject serial number value, unless specified
+	// otherwise in the spec file.
+	CertSerialNumber string
+
+	// SecretLabels are extra labels set on every CA and certificate secret,
+	// in addition to the ones set in the spec file.
+	SecretLabels map[string]string
+	// SecretAnnotations are extra annotations set on every CA and certificate
+	// secret, in addition to the ones set in the spec file.
+	SecretAnnotations map[string]string
 
 	// HubbleRelayClientCertGenerate can be set to true to generate and store a
 	// Hubble Relay client TLS certificate (used for the mTLS handshake with
@@ -337,6 +821,149 @@ type CertGenConfig struct {
 	ClustermeshApiserverRemoteCertSecretName string
 }
 
+// validateOutputSinks checks that the output sinks are known and configured.
+func (c *CertGenConfig) validateOutputSinks() error {
+	if len(c.OutputSinks) == 0 {
+		return fmt.Errorf("%s must not be empty", OutputSinks)
+	}
+	for _, sink := range c.OutputSinks {
+		switch sink {
+		case OutputSinkSecret, OutputSinkManifest:
+		case OutputSinkFile:
+			if c.OutputDir == "" {
+				return fmt.Errorf("%s must be set to use the %s output sink", OutputDir, sink)
+			}
+		default:
+			return fmt.Errorf("invalid output sink %q: must be one of %s, %s or %s",
+				sink, OutputSinkSecret, OutputSinkFile, OutputSinkManifest)
+		}
+	}
+	return nil
+}
+
+// validateCAKeyPassphrase checks that at most one source of the CA key
+// passphrase is configured, and that one is if the CA key is to be encrypted.
+func (c *CertGenConfig) validateCAKeyPassphrase() error {
+	sources := 0
+	for _, source := range []string{c.CAKeyPassphraseFile, c.CAKeyPassphraseEnv, c.CAKeyPassphraseSecretName} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("only one of %s, %s and %s can be set",
+			CAKeyPassphraseFile, CAKeyPassphraseEnv, CAKeyPassphraseSecretName)
+	}
+	if c.CAKeyPassphraseSecretName != "" && c.CAKeyPassphraseSecretKey == "" {
+		return fmt.Errorf("%s must be set to use %s", CAKeyPassphraseSecretKey, CAKeyPassphraseSecretName)
+	}
+	if c.CAKeyEncrypt && sources == 0 {
+		return fmt.Errorf("%s requires one of %s, %s or %s to be set",
+			CAKeyEncrypt, CAKeyPassphraseFile, CAKeyPassphraseEnv, CAKeyPassphraseSecretName)
+	}
+	return nil
+}
+
+// validateCSRSigner checks that the CSR signer, if enabled, is not combined
+// with another Cilium CA source, and that its CA bundle is configured.
+func (c *CertGenConfig) validateCSRSigner() error {
+	if c.CSRSignerName == "" {
+		return nil
+	}
+	if c.CAGenerate || c.CACertFile != "" || c.CAKeyFile != "" || c.RemoteSignerURL != "" {
+		return fmt.Errorf("%s cannot be used with %s, %s, %s or %s",
+			CSRSignerName, CAGenerate, CACertFile, CAKeyFile, RemoteSignerURL)
+	}
+	if (c.CSRCABundleConfigMapName == "") == (c.CSRCABundleSecretName == "") {
+		return fmt.Errorf("exactly one of %s and %s must be set to use %s",
+			CSRCABundleConfigMapName, CSRCABundleSecretName, CSRSignerName)
+	}
+	if c.CSRCABundleKey == "" {
+		return fmt.Errorf("%s must be set to use %s", CSRCABundleKey, CSRSignerName)
+	}
+	return nil
+}
+
+// validateCASigner checks that the Cilium CA signer, if enabled, signs with a
+// CA key held by certgen and has a valid signing policy.
+func (c *CertGenConfig) validateCASigner() error {
+	if c.CASignerName == "" {
+		return nil
+	}
+	if c.RemoteSignerURL != "" || c.CSRSignerName != "" {
+		return fmt.Errorf("%s cannot be used with %s or %s", CASignerName, RemoteSignerURL, CSRSignerName)
+	}
+	for _, usage := range c.CASignerAllowedUsages {
+		if _, ok := config.KeyUsage[usage]; ok {
+			continue
+		}
+		if _, ok := config.ExtKeyUsage[usage]; ok {
+			continue
+		}
+		return fmt.Errorf("invalid %s: unknown usage %q", CASignerAllowedUsages, usage)
+	}
+	for _, pattern := range c.CASignerAllowedDNSNames {
+		if err := generate.ValidateDNSNamePattern(pattern); err != nil {
+			return fmt.Errorf("invalid %s: %w", CASignerAllowedDNSNames, err)
+		}
+	}
+	for _, cidr := range c.CASignerAllowedIPRanges {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid %s: %w", CASignerAllowedIPRanges, err)
+		}
+	}
+	if c.CASignerMaxDuration <= 0 {
+		return fmt.Errorf("%s must be positive", CASignerMaxDuration)
+	}
+	return nil
+}
+
+// validateCertManager checks that the cert-manager mode, if enabled, can back
+// its Issuer with a Cilium CA secret holding the CA key.
+func (c *CertGenConfig) validateCertManager() error {
+	switch c.CertManagerMode {
+	case "":
+		return nil
+	case CertManagerModeEmit, CertManagerModeApply:
+	default:
+		return fmt.Errorf("invalid %s %q: must be %s or %s", CertManagerMode, c.CertManagerMode,
+			CertManagerModeEmit, CertManagerModeApply)
+	}
+	if c.CertManagerIssuerName == "" {
+		return fmt.Errorf("%s must be set to use %s", CertManagerIssuerName, CertManagerMode)
+	}
+	if c.DryRun || c.RemoteSignerURL != "" || c.CSRSignerName != "" || c.CAKeyEncrypt {
+		return fmt.Errorf("%s cannot be used with %s, %s, %s or %s",
+			CertManagerMode, DryRun, RemoteSignerURL, CSRSignerName, CAKeyEncrypt)
+	}
+	// The cert-manager CA issuer cannot read encrypted keys, and tls.key would
+	// be a copy of the encrypted CA key.
+	if c.CAKeyPassphraseFile != "" || c.CAKeyPassphraseEnv != "" || c.CAKeyPassphraseSecretName != "" {
+		return fmt.Errorf("%s cannot be used with an encrypted CA key (%s, %s or %s)",
+			CertManagerMode, CAKeyPassphraseFile, CAKeyPassphraseEnv, CAKeyPassphraseSecretName)
+	}
+	if !c.CAGenerate && !c.CAReuseSecret && !c.CAStoreFile {
+		return fmt.Errorf("%s requires the Cilium CA to be stored in its secret with %s, %s or %s",
+			CertManagerMode, CAGenerate, CAReuseSecret, CAStoreFile)
+	}
+	return nil
+}
+
+// CASigningPolicy returns the signing policy of the Cilium CA signer.
+func (c *CertGenConfig) CASigningPolicy() generate.SigningPolicy {
+	policy := generate.SigningPolicy{
+		AllowedUsages:   c.CASignerAllowedUsages,
+		AllowedDNSNames: c.CASignerAllowedDNSNames,
+		MaxDuration:     c.CASignerMaxDuration,
+	}
+	for _, cidr := range c.CASignerAllowedIPRanges {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			policy.AllowedIPRanges = append(policy.AllowedIPRanges, ipNet)
+		}
+	}
+	return policy
+}
+
 // getStringWithFallback returns the value associated with the key as a string
 // if it is non-empty. If the value is empty, this function returns the value
 // associated with fallbackKey
@@ -348,20 +975,140 @@ func getStringWithFallback(vp *viper.Viper, key, fallbackKey string) string { //
 }
 
 // PopulateFrom populates the config struct with the values provided by vp
-func (c *CertGenConfig) PopulateFrom(vp *viper.Viper) {
+func (c *CertGenConfig) PopulateFrom(vp *viper.Viper) error {
+	c.SpecFile = vp.GetString(SpecFile)
+	if err := readSpecFile(vp, c.SpecFile); err != nil {
+		return err
+	}
+
 	c.Debug = vp.GetBool(Debug)
+	c.DryRun = vp.GetBool(DryRun)
+	c.DryRunOutput = vp.GetString(DryRunOutput)
+	if c.DryRunOutput != "text" && c.DryRunOutput != "json" {
+		return fmt.Errorf("invalid %s %q: must be text or json", DryRunOutput, c.DryRunOutput)
+	}
+
+	c.OutputSinks = vp.GetStringSlice(OutputSinks)
+	c.OutputDir = vp.GetString(OutputDir)
+	c.RenderOutput = vp.GetString(RenderOutput)
+
+	c.ReportOutput = vp.GetString(ReportOutput)
+	c.ReportConfigMapName = vp.GetString(ReportConfigMapName)
+	c.ReportConfigMapNamespace = getStringWithFallback(vp, ReportConfigMapNamespace, CiliumNamespace)
+	c.Events = vp.GetBool(Events)
+	if c.ReportOutput == "-" && slices.Contains(c.OutputSinks, OutputSinkManifest) {
+		return fmt.Errorf("%s cannot be written to stdout with the %s output sink", ReportOutput, OutputSinkManifest)
+	}
+
+	c.InspectOutput = vp.GetString(InspectOutput)
+	if c.InspectOutput != "text" && c.InspectOutput != "json" {
+		return fmt.Errorf("invalid %s %q: must be text or json", InspectOutput, c.InspectOutput)
+	}
+	c.InspectWarnBefore = vp.GetString(InspectWarnBefore)
+	if _, err := generate.ParseRenewBefore(c.InspectWarnBefore); err != nil {
+		return fmt.Errorf("invalid %s: %w", InspectWarnBefore, err)
+	}
+	if err := c.validateOutputSinks(); err != nil {
+		return err
+	}
 	c.K8sKubeConfigPath = vp.GetString(K8sKubeConfigPath)
 	c.K8sRequestTimeout = vp.GetDuration(K8sRequestTimeout)
+	c.K8sClientQPS = float32(vp.GetFloat64(K8sClientQPS))
+	c.K8sClientBurst = vp.GetInt(K8sClientBurst)
+	c.Workers = vp.GetInt(Workers)
+	c.ForceConflicts = vp.GetBool(ForceConflicts)
+
+	c.LeaseLock = vp.GetBool(LeaseLock)
+	c.LeaseLockName = vp.GetString(LeaseLockName)
+	c.LeaseLockNamespace = getStringWithFallback(vp, LeaseLockNamespace, CiliumNamespace)
+	c.LeaseLockTimeout = vp.GetDuration(LeaseLockTimeout)
+	if c.LeaseLock && c.LeaseLockName == "" {
+		return fmt.Errorf("%s must be set to use %s", LeaseLockName, LeaseLock)
+	}
 
 	c.CACertFile = vp.GetString(CACertFile)
 	c.CAKeyFile = vp.GetString(CAKeyFile)
+	c.CAStoreFile = vp.GetBool(CAStoreFile)
 
 	c.CAGenerate = vp.GetBool(CAGenerate)
 	c.CAReuseSecret = vp.GetBool(CAReuseSecret)
+	if c.CAStoreFile && (c.CAGenerate || c.CACertFile == "" || c.CAKeyFile == "") {
+		return fmt.Errorf("%s requires %s and %s, and cannot be used with %s", CAStoreFile, CACertFile, CAKeyFile, CAGenerate)
+	}
+	c.CAKeyPassphraseFile = vp.GetString(CAKeyPassphraseFile)
+	c.CAKeyPassphraseEnv = vp.GetString(CAKeyPassphraseEnv)
+	c.CAKeyPassphraseSecretName = vp.GetString(CAKeyPassphraseSecretName)
+	c.CAKeyPassphraseSecretNamespace = getStringWithFallback(vp, CAKeyPassphraseSecretNamespace, CiliumNamespace)
+	c.CAKeyPassphraseSecretKey = vp.GetString(CAKeyPassphraseSecretKey)
+	c.CAKeyEncrypt = vp.GetBool(CAKeyEncrypt)
+	if err := c.validateCAKeyPassphrase(); err != nil {
+		return err
+	}
+
+	c.RemoteSignerURL = vp.GetString(RemoteSignerURL)
+	c.RemoteSignerProfile = vp.GetString(RemoteSignerProfile)
+	c.RemoteSignerLabel = vp.GetString(RemoteSignerLabel)
+	c.RemoteSignerAuthKeyFile = vp.GetString(RemoteSignerAuthKeyFile)
+	c.RemoteSignerTLSCAFile = vp.GetString(RemoteSignerTLSCAFile)
+	c.RemoteSignerCAChainFile = vp.GetString(RemoteSignerCAChainFile)
+	c.RemoteSignerTimeout = vp.GetDuration(RemoteSignerTimeout)
+	if c.RemoteSignerURL != "" && (c.CAGenerate || c.CACertFile != "" || c.CAKeyFile != "") {
+		return fmt.Errorf("%s cannot be used with %s, %s or %s", RemoteSignerURL, CAGenerate, CACertFile, CAKeyFile)
+	}
+
+	c.CSRSignerName = vp.GetString(CSRSignerName)
+	c.CSRAutoApprove = vp.GetBool(CSRAutoApprove)
+	c.CSRTimeout = vp.GetDuration(CSRTimeout)
+	c.CSRCABundleConfigMapName = vp.GetString(CSRCABundleConfigMapName)
+	c.CSRCABundleSecretName = vp.GetString(CSRCABundleSecretName)
+	c.CSRCABundleNamespace = getStringWithFallback(vp, CSRCABundleNamespace, CiliumNamespace)
+	c.CSRCABundleKey = vp.GetString(CSRCABundleKey)
+	if err := c.validateCSRSigner(); err != nil {
+		return err
+	}
+
+	c.CASignerName = vp.GetString(CASignerName)
+	c.CASignerAllowedUsages = vp.GetStringSlice(CASignerAllowedUsages)
+	c.CASignerAllowedDNSNames = vp.GetStringSlice(CASignerAllowedDNSNames)
+	c.CASignerAllowedIPRanges = vp.GetStringSlice(CASignerAllowedIPRanges)
+	c.CASignerMaxDuration = vp.GetDuration(CASignerMaxDuration)
+	if err := c.validateCASigner(); err != nil {
+		return err
+	}
+
+	c.CertManagerMode = vp.GetString(CertManagerMode)
+	c.CertManagerIssuerName = vp.GetString(CertManagerIssuerName)
+	if err := c.validateCertManager(); err != nil {
+		return err
+	}
 	c.CACommonName = vp.GetString(CACommonName)
 	c.CAValidityDuration = vp.GetDuration(CAValidityDuration)
+	c.CAKeyAlgorithm = vp.GetString(CAKeyAlgorithm)
+	c.CAKeySize = vp.GetInt(CAKeySize)
+	c.CACountry = vp.GetString(CACountry)
+	c.CAState = vp.GetString(CAState)
+	c.CALocality = vp.GetString(CALocality)
+	c.CAOrganization = vp.GetString(CAOrganization)
+	c.CAOrganizationalUnit = vp.GetString(CAOrganizationalUnit)
+	c.CASerialNumber = vp.GetString(CASerialNumber)
 	c.CASecretName = vp.GetString(CASecretName)
 	c.CASecretNamespace = getStringWithFallback(vp, CASecretNamespace, CiliumNamespace)
+	c.CARotate = vp.GetBool(CARotate)
+	c.CARotationGracePeriod = vp.GetDuration(CARotationGracePeriod)
+
+	c.CertReuseSecret = vp.GetBool(CertReuseSecret)
+	c.CertRenewBefore = vp.GetString(CertRenewBefore)
+	c.CertKeyAlgorithm = vp.GetString(CertKeyAlgorithm)
+	c.CertKeySize = vp.GetInt(CertKeySize)
+	c.CertCountry = vp.GetString(CertCountry)
+	c.CertState = vp.GetString(CertState)
+	c.CertLocality = vp.GetString(CertLocality)
+	c.CertOrganization = vp.GetString(CertOrganization)
+	c.CertOrganizationalUnit = vp.GetString(CertOrganizationalUnit)
+	c.CertSerialNumber = vp.GetString(CertSerialNumber)
+
+	c.SecretLabels = vp.GetStringMapString(SecretLabels)
+	c.SecretAnnotations = vp.GetStringMapString(SecretAnnotations)
 
 	c.HubbleRelayClientCertGenerate = vp.GetBool(HubbleRelayClientCertGenerate)
 	c.HubbleRelayClientCertCommonName = vp.GetString(HubbleRelayClientCertCommonName)
@@ -409,4 +1156,6 @@ func (c *CertGenConfig) PopulateFrom(vp *viper.Viper) {
 	c.ClustermeshApiserverRemoteCertCommonName = vp.GetString(ClustermeshApiserverRemoteCertCommonName)
 	c.ClustermeshApiserverRemoteCertValidityDuration = vp.GetDuration(ClustermeshApiserverRemoteCertValidityDuration)
 	c.ClustermeshApiserverRemoteCertSecretName = vp.GetString(ClustermeshApiserverRemoteCertSecretName)
+
+	return c.populateSpecsFrom(vp)
 }
@@ -0,0 +1,496 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package option
+
+import (
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/cilium/certgen/internal/defaults"
+	"github.com/cilium/certgen/internal/generate"
+)
+
+const (
+	// specCertificatesKey is the key of the spec file listing the
+	// certificates to generate.
+	specCertificatesKey = "certificates"
+	// specCAsKey is the key of the spec file listing the additional CAs
+	// certificates can be issued by.
+	specCAsKey = "cas"
+)
+
+// CertificateSpec describes a certificate generated by certgen and the K8s
+// Secret it is stored in.
+type CertificateSpec struct {
+	// Name identifies the certificate in logs. Defaults to the secret name.
+	Name string `mapstructure:"name"`
+	// CommonName is the x509 certificate CN value.
+	CommonName string `mapstructure:"commonName"`
+	// Hosts is the list of DNS names and IP addresses added as SANs.
+	// Defaults to the CommonName.
+	Hosts []string `mapstructure:"hosts"`
+	// Usage are the key usages of the x509 certificate.
+	Usage []string `mapstructure:"usage"`
+	// ValidityDuration represent how much time the certificate is valid.
+	ValidityDuration time.Duration `mapstructure:"validityDuration"`
+	// RenewBefore is the window before expiry in which the certificate is
+	// renewed, as duration or percentage of the lifetime. Defaults to the
+	// value of the cert-renew-before option.
+	RenewBefore string `mapstructure:"renewBefore"`
+	// Key configures the private key of the certificate. Defaults to the
+	// values of the cert-key-algorithm and cert-key-size options.
+	Key KeySpec `mapstructure:"key"`
+	// Subject configures the x509 subject fields besides the CommonName.
+	// Defaults to the values of the cert-* subject options.
+	Subject SubjectSpec `mapstructure:"subject"`
+	// SecretName is the Kubernetes Secret in which the certificate is
+	// written to.
+	SecretName string `mapstructure:"secretName"`
+	// SecretNamespace is the Kubernetes Namespace in which the certificate
+	// Secret will be stored. Defaults to the Cilium namespace.
+	SecretNamespace string `mapstructure:"secretNamespace"`
+	// CA is the name of the CA issuing the certificate. Defaults to the
+	// Cilium CA.
+	CA string `mapstructure:"ca"`
+	// Labels and Annotations are set on the certificate secret, in addition
+	// to the secret-labels and secret-annotations options.
+	Labels      map[string]string `mapstructure:"labels"`
+	Annotations map[string]string `mapstructure:"annotations"`
+
+	// validityDurationSet is true if ValidityDuration is set explicitly
+	// rather than defaulted.
+	validityDurationSet bool
+}
+
+// NewCert creates the blueprint of the certificate described by s.
+func (s CertificateSpec) NewCert() *generate.Cert {
+	return generate.NewCert(
+		s.CommonName,
+		s.ValidityDuration,
+		s.Usage,
+		s.SecretName,
+		s.SecretNamespace,
+	).WithHosts(s.Hosts).
+		WithKey(s.Key.Algorithm, s.Key.Size).
+		WithSubject(generate.Subject(s.Subject)).
+		WithMetadata(generate.Metadata{Component: s.Name, Labels: s.Labels, Annotations: s.Annotations})
+}
+
+// KeySpec describes the private key of a certificate.
+type KeySpec struct {
+	// Algorithm is the key algorithm (ecdsa, rsa or ed25519).
+	Algorithm string `mapstructure:"algorithm"`
+	// Size is the key size in bits (or the curve size for ECDSA keys).
+	// Zero selects the default size of the algorithm (256 for ECDSA and 2048
+	// for RSA).
+	Size int `mapstructure:"size"`
+}
+
+// SubjectSpec describes the x509 subject fields of a certificate besides the
+// common name. Empty fields are omitted.
+type SubjectSpec struct {
+	// Country is the country (C) value.
+	Country string `mapstructure:"country"`
+	// State is the state or province (ST) value.
+	State string `mapstructure:"state"`
+	// Locality is the locality (L) value.
+	Locality string `mapstructure:"locality"`
+	// Organization is the organization (O) value.
+	Organization string `mapstructure:"organization"`
+	// OrganizationalUnit is the organizational unit (OU) value.
+	OrganizationalUnit string `mapstructure:"organizationalUnit"`
+	// SerialNumber is the subject serial number value.
+	SerialNumber string `mapstructure:"serialNumber"`
+}
+
+// CASpec describes an additional CA that certificates can be issued by. The
+// CA is loaded from its K8s Secret, and generated and stored if the Secret
+// does not exist yet. If Parent is set, the CA is an intermediate CA signed by
+// the parent CA.
+type CASpec struct {
+	// Name identifies the CA in the CA field of certificate specs.
+	Name string `mapstructure:"name"`
+	// CommonName is the CA x509 certificate CN value.
+	CommonName string `mapstructure:"commonName"`
+	// ValidityDuration represent how much time the CA certificate is valid.
+	ValidityDuration time.Duration `mapstructure:"validityDuration"`
+	// SecretName is the Kubernetes Secret in which the CA certificate is read
+	// from and/or written to.
+	SecretName string `mapstructure:"secretName"`
+	// SecretNamespace is the Kubernetes Namespace in which the CA Secret will
+	// be stored. Defaults to the Cilium namespace.
+	SecretNamespace string `mapstructure:"secretNamespace"`
+	// Key configures the private key of the CA. Defaults to the key of the
+	// Cilium CA.
+	Key KeySpec `mapstructure:"key"`
+	// Subject configures the x509 subject fields besides the CommonName.
+	// Defaults to the subject of the Cilium CA.
+	Subject SubjectSpec `mapstructure:"subject"`
+	// Parent is the name of the CA signing this intermediate CA, which must
+	// be listed before it. Empty for root CAs.
+	Parent string `mapstructure:"parent"`
+	// Labels and Annotations are set on the CA secret, in addition to the
+	// secret-labels and secret-annotations options.
+	Labels      map[string]string `mapstructure:"labels"`
+	Annotations map[string]string `mapstructure:"annotations"`
+}
+
+// NewCA creates the CA blueprint described by the spec.
+func (s CASpec) NewCA() *generate.CA {
+	return generate.NewCA(s.SecretName, s.SecretNamespace).
+		WithKey(s.Key.Algorithm, s.Key.Size).
+		WithSubject(generate.Subject(s.Subject)).
+		WithMetadata(generate.Metadata{Component: s.Name, Labels: s.Labels, Annotations: s.Annotations})
+}
+
+// readSpecFile merges the spec file (if any) into vp, so that it can provide
+// both the certificate specs and the values of the other options.
+func readSpecFile(vp *viper.Viper, specFile string) error {
+	if specFile == "" {
+		return nil
+	}
+	vp.SetConfigFile(specFile)
+	if err := vp.ReadInConfig(); err != nil {
+		return fmt.Errorf("failed to read spec file %s: %w", specFile, err)
+	}
+	return nil
+}
+
+// populateSpecsFrom populates c.CAs and c.Certificates from the spec file
+// entries and the per-component options.
+func (c *CertGenConfig) populateSpecsFrom(vp *viper.Viper) error {
+	var cas []CASpec
+	if err := vp.UnmarshalKey(specCAsKey, &cas); err != nil {
+		return fmt.Errorf("failed to parse %q from spec file: %w", specCAsKey, err)
+	}
+	var certs []CertificateSpec
+	if err := vp.UnmarshalKey(specCertificatesKey, &certs); err != nil {
+		return fmt.Errorf("failed to parse %q from spec file: %w", specCertificatesKey, err)
+	}
+	// A zero validityDuration cannot be told apart from a missing one once
+	// unmarshalled.
+	var validities []struct {
+		ValidityDuration *time.Duration `mapstructure:"validityDuration"`
+	}
+	if err := vp.UnmarshalKey(specCertificatesKey, &validities); err != nil {
+		return fmt.Errorf("failed to parse %q from spec file: %w", specCertificatesKey, err)
+	}
+
+	c.CAs = nil
+	for _, ca := range cas {
+		if ca.ValidityDuration == 0 {
+			ca.ValidityDuration = defaults.CAValidityDuration
+		}
+		if ca.SecretNamespace == "" {
+			ca.SecretNamespace = c.CiliumNamespace
+		}
+		if ca.Key.Algorithm == "" {
+			ca.Key.Algorithm = c.CAKeyAlgorithm
+		}
+		if ca.Key.Size == 0 && ca.Key.Algorithm == c.CAKeyAlgorithm {
+			ca.Key.Size = c.CAKeySize
+		}
+		if ca.Subject == (SubjectSpec{}) {
+			ca.Subject = SubjectSpec(c.CASubject())
+		}
+		ca.Labels = mergeMaps(c.SecretLabels, ca.Labels)
+		ca.Annotations = mergeMaps(c.SecretAnnotations, ca.Annotations)
+		c.CAs = append(c.CAs, ca)
+	}
+
+	c.Certificates = c.componentCertificates(vp)
+	for i := range c.Certificates {
+		c.Certificates[i].RenewBefore = c.CertRenewBefore
+		c.Certificates[i].Key = KeySpec{Algorithm: c.CertKeyAlgorithm, Size: c.CertKeySize}
+		c.Certificates[i].Subject = c.certSubject()
+		c.Certificates[i].Labels = c.SecretLabels
+		c.Certificates[i].Annotations = c.SecretAnnotations
+	}
+	for i, cert := range certs {
+		cert.validityDurationSet = validities[i].ValidityDuration != nil
+		if cert.Name == "" {
+			cert.Name = cert.SecretName
+		}
+		if len(cert.Hosts) == 0 && cert.CommonName != "" {
+			cert.Hosts = []string{cert.CommonName}
+		}
+		if cert.ValidityDuration == 0 {
+			cert.ValidityDuration = defaults.CertValidityDuration
+		}
+		if cert.SecretNamespace == "" {
+			cert.SecretNamespace = c.CiliumNamespace
+		}
+		if cert.RenewBefore == "" {
+			cert.RenewBefore = c.CertRenewBefore
+		}
+		if cert.CA == "" {
+			cert.CA = defaults.CAName
+		}
+		if cert.Key.Algorithm == "" {
+			cert.Key.Algorithm = c.CertKeyAlgorithm
+		}
+		if cert.Key.Size == 0 && cert.Key.Algorithm == c.CertKeyAlgorithm {
+			cert.Key.Size = c.CertKeySize
+		}
+		if cert.Subject == (SubjectSpec{}) {
+			cert.Subject = c.certSubject()
+		}
+		cert.Labels = mergeMaps(c.SecretLabels, cert.Labels)
+		cert.Annotations = mergeMaps(c.SecretAnnotations, cert.Annotations)
+		c.Certificates = append(c.Certificates, cert)
+	}
+
+	return c.validateSpecs()
+}
+
+// CAMetadata returns the extra labels and annotations of the Cilium CA secret.
+func (c *CertGenConfig) CAMetadata() generate.Metadata {
+	return generate.Metadata{
+		Component:   defaults.CAName,
+		Labels:      c.SecretLabels,
+		Annotations: c.SecretAnnotations,
+	}
+}
+
+// mergeMaps returns the entries of base, overridden by the ones of override.
+func mergeMaps(base, override map[string]string) map[string]string {
+	merged := maps.Clone(base)
+	if merged == nil {
+		merged = make(map[string]string, len(override))
+	}
+	maps.Copy(merged, override)
+	return merged
+}
+
+// CASubject returns the subject fields of the Cilium CA.
+func (c *CertGenConfig) CASubject() generate.Subject {
+	return generate.Subject{
+		Country:            c.CACountry,
+		State:              c.CAState,
+		Locality:           c.CALocality,
+		Organization:       c.CAOrganization,
+		OrganizationalUnit: c.CAOrganizationalUnit,
+		SerialNumber:       c.CASerialNumber,
+	}
+}
+
+// certSubject returns the default subject fields of the certificates.
+func (c *CertGenConfig) certSubject() SubjectSpec {
+	return SubjectSpec{
+		Country:            c.CertCountry,
+		State:              c.CertState,
+		Locality:           c.CertLocality,
+		Organization:       c.CertOrganization,
+		OrganizationalUnit: c.CertOrganizationalUnit,
+		SerialNumber:       c.CertSerialNumber,
+	}
+}
+
+// componentCertificates translates the per-component options into the
+// equivalent certificate specs.
+func (c *CertGenConfig) componentCertificates(vp *viper.Viper) []CertificateSpec {
+	var certs []CertificateSpec
+
+	if c.HubbleServerCertGenerate {
+		certs = append(certs, CertificateSpec{
+			Name:                "hubble-server",
+			CommonName:          c.HubbleServerCertCommonName,
+			Hosts:               []string{c.HubbleServerCertCommonName},
+			Usage:               defaults.HubbleServerCertUsage,
+			ValidityDuration:    c.HubbleServerCertValidityDuration,
+			validityDurationSet: vp.IsSet(HubbleServerCertValidityDuration),
+			SecretName:          c.HubbleServerCertSecretName,
+			SecretNamespace:     c.HubbleServerCertSecretNamespace,
+			CA:                  defaults.CAName,
+		})
+	}
+
+	if c.HubbleMetricsServerCertGenerate {
+		certs = append(certs, CertificateSpec{
+			Name:                "hubble-metrics-server",
+			CommonName:          c.HubbleMetricsServerCertCommonName,
+			Hosts:               []string{c.HubbleMetricsServerCertCommonName},
+			Usage:               defaults.HubbleMetricsServerCertUsage,
+			ValidityDuration:    c.HubbleMetricsServerCertValidityDuration,
+			validityDurationSet: vp.IsSet(HubbleMetricsServerCertValidityDuration),
+			SecretName:          c.HubbleMetricsServerCertSecretName,
+			SecretNamespace:     c.HubbleMetricsServerCertSecretNamespace,
+			CA:                  defaults.CAName,
+		})
+	}
+
+	if c.HubbleRelayClientCertGenerate {
+		certs = append(certs, CertificateSpec{
+			Name:                "hubble-relay-client",
+			CommonName:          c.HubbleRelayClientCertCommonName,
+			Hosts:               []string{c.HubbleRelayClientCertCommonName},
+			Usage:               defaults.HubbleRelayClientCertUsage,
+			ValidityDuration:    c.HubbleRelayClientCertValidityDuration,
+			validityDurationSet: vp.IsSet(HubbleRelayClientCertValidityDuration),
+			SecretName:          c.HubbleRelayClientCertSecretName,
+			SecretNamespace:     c.HubbleRelayClientCertSecretNamespace,
+			CA:                  defaults.CAName,
+		})
+	}
+
+	if c.HubbleRelayServerCertGenerate {
+		certs = append(certs, CertificateSpec{
+			Name:                "hubble-relay-server",
+			CommonName:          c.HubbleRelayServerCertCommonName,
+			Hosts:               []string{c.HubbleRelayServerCertCommonName},
+			Usage:               defaults.HubbleRelayServerCertUsage,
+			ValidityDuration:    c.HubbleRelayServerCertValidityDuration,
+			validityDurationSet: vp.IsSet(HubbleRelayServerCertValidityDuration),
+			SecretName:          c.HubbleRelayServerCertSecretName,
+			SecretNamespace:     c.HubbleRelayServerCertSecretNamespace,
+			CA:                  defaults.CAName,
+		})
+	}
+
+	if c.ClustermeshApiserverServerCertGenerate {
+		certs = append(certs, CertificateSpec{
+			Name:       "clustermesh-apiserver-server",
+			CommonName: c.ClustermeshApiserverServerCertCommonName,
+			Hosts: append([]string{
+				c.ClustermeshApiserverServerCertCommonName,
+				"127.0.0.1",
+			}, c.ClustermeshApiserverServerCertSANs...),
+			Usage:               defaults.ClustermeshApiserverCertUsage,
+			ValidityDuration:    c.ClustermeshApiserverServerCertValidityDuration,
+			validityDurationSet: vp.IsSet(ClustermeshApiserverServerCertValidityDuration),
+			SecretName:          c.ClustermeshApiserverServerCertSecretName,
+			SecretNamespace:     c.CiliumNamespace,
+			CA:                  defaults.CAName,
+		})
+	}
+
+	if c.ClustermeshApiserverAdminCertGenerate {
+		certs = append(certs, CertificateSpec{
+			Name:                "clustermesh-apiserver-admin",
+			CommonName:          c.ClustermeshApiserverAdminCertCommonName,
+			Hosts:               []string{"localhost"},
+			Usage:               defaults.ClustermeshApiserverCertUsage,
+			ValidityDuration:    c.ClustermeshApiserverAdminCertValidityDuration,
+			validityDurationSet: vp.IsSet(ClustermeshApiserverAdminCertValidityDuration),
+			SecretName:          c.ClustermeshApiserverAdminCertSecretName,
+			SecretNamespace:     c.CiliumNamespace,
+			CA:                  defaults.CAName,
+		})
+	}
+
+	if c.ClustermeshApiserverClientCertGenerate {
+		certs = append(certs, CertificateSpec{
+			Name:                "clustermesh-apiserver-client",
+			CommonName:          c.ClustermeshApiserverClientCertCommonName,
+			Hosts:               []string{c.ClustermeshApiserverClientCertCommonName},
+			Usage:               defaults.ClustermeshApiserverCertUsage,
+			ValidityDuration:    c.ClustermeshApiserverClientCertValidityDuration,
+			validityDurationSet: vp.IsSet(ClustermeshApiserverClientCertValidityDuration),
+			SecretName:          c.ClustermeshApiserverClientCertSecretName,
+			SecretNamespace:     c.CiliumNamespace,
+			CA:                  defaults.CAName,
+		})
+	}
+
+	if c.ClustermeshApiserverRemoteCertGenerate {
+		certs = append(certs, CertificateSpec{
+			Name:                "clustermesh-apiserver-remote",
+			CommonName:          c.ClustermeshApiserverRemoteCertCommonName,
+			Hosts:               []string{c.ClustermeshApiserverRemoteCertCommonName},
+			Usage:               defaults.ClustermeshApiserverCertUsage,
+			ValidityDuration:    c.ClustermeshApiserverRemoteCertValidityDuration,
+			validityDurationSet: vp.IsSet(ClustermeshApiserverRemoteCertValidityDuration),
+			SecretName:          c.ClustermeshApiserverRemoteCertSecretName,
+			SecretNamespace:     c.CiliumNamespace,
+			CA:                  defaults.CAName,
+		})
+	}
+
+	return certs
+}
+
+// validateSpecs checks that the CA and certificate specs are complete and
+// do not conflict with each other.
+func (c *CertGenConfig) validateSpecs() error {
+	if err := generate.ValidateKey(c.CAKeyAlgorithm, c.CAKeySize); err != nil {
+		return fmt.Errorf("Cilium CA: %w", err)
+	}
+
+	cas := map[string]struct{}{defaults.CAName: {}}
+	for i, ca := range c.CAs {
+		switch {
+		case ca.Name == "":
+			return fmt.Errorf("CA #%d: name must be provided", i)
+		case ca.CommonName == "":
+			return fmt.Errorf("CA %s: commonName must be provided", ca.Name)
+		case ca.SecretName == "":
+			return fmt.Errorf("CA %s: secretName must be provided", ca.Name)
+		}
+		if _, ok := cas[ca.Name]; ok {
+			return fmt.Errorf("CA %s: name is already in use", ca.Name)
+		}
+		if err := generate.ValidateKey(ca.Key.Algorithm, ca.Key.Size); err != nil {
+			return fmt.Errorf("CA %s: %w", ca.Name, err)
+		}
+		if _, ok := cas[ca.Parent]; ca.Parent != "" && !ok {
+			return fmt.Errorf("CA %s: parent CA %q must be listed before it", ca.Name, ca.Parent)
+		}
+		cas[ca.Name] = struct{}{}
+	}
+
+	secrets := make(map[string]string, len(c.Certificates))
+	for i, cert := range c.Certificates {
+		switch {
+		case cert.SecretName == "":
+			return fmt.Errorf("certificate #%d: secretName must be provided", i)
+		case cert.CommonName == "":
+			return fmt.Errorf("certificate %s: commonName must be provided", cert.Name)
+		case len(cert.Usage) == 0:
+			return fmt.Errorf("certificate %s: usage must be provided", cert.Name)
+		case cert.ValidityDuration < 0:
+			return fmt.Errorf("certificate %s: validityDuration must be positive", cert.Name)
+		}
+		if err := generate.ValidateKey(cert.Key.Algorithm, cert.Key.Size); err != nil {
+			return fmt.Errorf("certificate %s: %w", cert.Name, err)
+		}
+		if _, err := generate.ParseRenewBefore(cert.RenewBefore); err != nil {
+			return fmt.Errorf("certificate %s: %w", cert.Name, err)
+		}
+		if _, ok := cas[cert.CA]; !ok {
+			return fmt.Errorf("certificate %s: unknown CA %q", cert.Name, cert.CA)
+		}
+		// The cfssl sign API has no validity parameter, the validity of the
+		// certificates is set by the remote signing profile.
+		if c.RemoteSignerURL != "" && cert.CA == defaults.CAName && cert.validityDurationSet {
+			return fmt.Errorf("certificate %s: validity duration cannot be set with %s, the validity is set by the remote signing profile",
+				cert.Name, RemoteSignerURL)
+		}
+		secret := cert.SecretNamespace + "/" + cert.SecretName
+		if other, ok := secrets[secret]; ok {
+			return fmt.Errorf("certificate %s: secret %s is already used by certificate %s", cert.Name, secret, other)
+		}
+		secrets[secret] = cert.Name
+	}
+
+	return nil
+}
+
+// ValidateRenewBefore checks that the renewal window of every certificate is
+// shorter than its validity duration. It is only checked by the modes renewing
+// the certificates, as the renewal window is unused otherwise.
+func (c *CertGenConfig) ValidateRenewBefore() error {
+	for _, cert := range c.Certificates {
+		renewBefore, err := generate.ParseRenewBefore(cert.RenewBefore)
+		if err != nil {
+			return fmt.Errorf("certificate %s: %w", cert.Name, err)
+		}
+		if renewBefore.Duration >= cert.ValidityDuration {
+			return fmt.Errorf("certificate %s: renewal window %s must be shorter than the validity duration", cert.Name, renewBefore)
+		}
+	}
+	return nil
+}